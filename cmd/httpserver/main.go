@@ -1,49 +1,53 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/compress"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/router"
 	"httpfromtcp/internal/server"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"syscall"
+	"time"
 )
 
 const port = 42069
 
 func httpBinProxyHandler(w *response.Writer, r *request.Request) {
-	path := strings.TrimPrefix(r.RequestLine.RequestTarget, "/httpbin")
-	url := "https://httpbin.org" + path
+	target := url.URL{
+		Scheme:   "https",
+		Host:     "httpbin.org",
+		Path:     "/" + r.PathValue("*"),
+		RawQuery: r.URL.RawQuery,
+	}
 
-	res, err := http.Get(url)
+	res, err := http.Get(target.String())
 	if err != nil {
 		log.Printf("error getting response: %v", err)
+		w.WriteHeader(response.StatusBadGateway)
 		return
 	}
 	defer res.Body.Close()
 
-	defHeaders := response.GetDefaultHeaders(0)
-	delete(defHeaders, "Content-Length")
-	defHeaders["Transfer-Encoding"] = "chunked"
-	defHeaders["Trailer"] = "X-Content-SHA256, X-Content-Length"
-
-	w.WriteStatusLine(response.StatusCode(res.StatusCode))
-	w.WriteHeaders(defHeaders)
+	w.DeclareTrailer("X-Content-SHA256")
+	w.DeclareTrailer("X-Content-Length")
+	w.WriteHeader(response.StatusCode(res.StatusCode))
 
 	chunk := make([]byte, 1024)
 	rawBody := []byte{}
 	for {
 		n, err := res.Body.Read(chunk)
 		if err == io.EOF {
-			w.WriteChunkedBodyDone()
 			break
 		}
 		if err != nil {
@@ -53,16 +57,12 @@ func httpBinProxyHandler(w *response.Writer, r *request.Request) {
 		fmt.Println("data read:", n)
 
 		rawBody = append(rawBody, chunk[:n]...)
-		w.WriteChunkedBody(chunk[:n])
+		w.Write(chunk[:n])
 	}
 
-	trailers := headers.NewHeaders()
-
 	sum := sha256.Sum256(rawBody)
-	trailers["X-Content-SHA256"] = string(sum[:])
-	trailers["X-Content-Length"] = strconv.Itoa(len(rawBody))
-
-	w.WriteTrailers(trailers)
+	w.Trailer().Set("X-Content-SHA256", hex.EncodeToString(sum[:]))
+	w.Trailer().Set("X-Content-Length", strconv.Itoa(len(rawBody)))
 }
 
 var resBody200 = `<html>
@@ -93,54 +93,83 @@ var resBody500 = `<html>
   </body>
 </html>`
 
-func handler(w *response.Writer, r *request.Request) {
-	if strings.HasPrefix(r.RequestLine.RequestTarget, "/httpbin") {
-		httpBinProxyHandler(w, r)
+func writePage(w *response.Writer, statusCode response.StatusCode, resBody string) {
+	w.WriteHeader(statusCode)
+	w.Write([]byte(resBody))
+}
+
+func okHandler(w *response.Writer, r *request.Request) {
+	writePage(w, response.StatusOK, resBody200)
+}
+
+func yourProblemHandler(w *response.Writer, r *request.Request) {
+	writePage(w, response.StatusBadRequest, resBody400)
+}
+
+func myProblemHandler(w *response.Writer, r *request.Request) {
+	writePage(w, response.StatusInternalServerError, resBody500)
+}
+
+func videoHandler(w *response.Writer, r *request.Request) {
+	data, err := os.ReadFile("assets/vim.mp4")
+	if err != nil {
+		log.Printf("error reading file: %v", err)
+		writePage(w, response.StatusBadRequest, resBody400)
 		return
 	}
 
-	resStatus := response.StatusOK
-	resBody := resBody200
-	defHeaders := response.GetDefaultHeaders(len(resBody))
-
-	switch r.RequestLine.RequestTarget {
-	case "/yourproblem":
-		resStatus = response.StatusBadRequest
-		resBody = resBody400
-		defHeaders["Content-Length"] = strconv.Itoa(len(resBody))
-	case "/myproblem":
-		resStatus = response.StatusInternalServerError
-		resBody = resBody500
-		defHeaders["Content-Length"] = strconv.Itoa(len(resBody))
-	case "/video":
-		data, err := os.ReadFile("assets/vim.mp4")
-		if err != nil {
-			log.Printf("error reading file: %v", err)
-			resBody = resBody400
-		} else {
-			defHeaders["Content-Type"] = "video/mp4"
-			resBody = string(data)
-		}
-		defHeaders["Content-Length"] = strconv.Itoa(len(resBody))
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+func logRequests(next server.Handler) server.Handler {
+	return func(w *response.Writer, r *request.Request) {
+		start := time.Now()
+		next(w, r)
+		log.Printf("%v %v %v %vB %v", r.RequestLine.Method, r.RequestLine.RequestTarget,
+			w.StatusCode(), w.BytesWritten(), time.Since(start))
 	}
+}
 
-	w.WriteStatusLine(resStatus)
-	w.WriteHeaders(defHeaders)
-	w.WriteBody([]byte(resBody))
+func newRouter() *router.Router {
+	rt := router.New()
+	rt.Handle("GET", "/httpbin/*", httpBinProxyHandler)
+	rt.Handle("GET", "/yourproblem", yourProblemHandler)
+	rt.Handle("GET", "/myproblem", myProblemHandler)
+	rt.Handle("GET", "/video", videoHandler)
+	rt.Handle("GET", "/*", okHandler)
+
+	return rt
 }
 
 func main() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
 
-	server, err := server.Serve(port, handler)
+	server := &server.Server{
+		Port:              port,
+		Handler:           server.Chain(newRouter().ServeHTTP, logRequests, compress.Middleware),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+	err := server.Start()
 	if err != nil {
 		log.Fatalf("error starting server: %v\n", err)
 	}
-	defer server.Close()
 	log.Println("server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err = server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("error shutting down server: %v\n", err)
+		return
+	}
 	log.Println("server gracefully stopped")
 }
@@ -41,12 +41,18 @@ func main() {
 			req.RequestLine.HttpVersion)
 
 		fmt.Println("Headers:")
-		for k, v := range req.Headers {
+		for k, v := range req.Headers.All() {
 			fmt.Printf("- %v: %v\n", k, v)
 		}
 
+		body, err := req.ReadBody()
+		if err != nil {
+			log.Printf("error reading request body: %v", err)
+			break
+		}
+
 		fmt.Println("Body:")
-		fmt.Println(string(req.Body))
+		fmt.Println(string(body))
 	}
 	log.Println("connection closed")
 }
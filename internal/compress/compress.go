@@ -0,0 +1,132 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+// Middleware compresses response bodies with gzip or deflate when the client
+// accepts it in Accept-Encoding and the Content-Type is worth compressing.
+// Compressed responses are sent chunked, as their length is not known in
+// advance. Responses that already have a Content-Encoding and media types
+// that are compressed themselves, such as images and video, are left alone.
+func Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, r *request.Request) {
+		coding := negotiate(r.Headers.Values("accept-encoding"))
+		w.BodyEncoder = func(statusCode response.StatusCode, h *headers.Headers, dst io.Writer) io.WriteCloser {
+			return encoder(coding, statusCode, h, dst)
+		}
+
+		next(w, r)
+	}
+}
+
+func encoder(coding string, statusCode response.StatusCode, h *headers.Headers, dst io.Writer) io.WriteCloser {
+	if !statusCode.AllowsBody() {
+		return nil
+	}
+	if h.Has("content-encoding") || h.Get("content-length") == "0" ||
+		!compressible(h.Get("content-type")) {
+		return nil
+	}
+
+	h.Add("Vary", "Accept-Encoding")
+	switch coding {
+	case "gzip":
+		h.Set("Content-Encoding", "gzip")
+		return gzip.NewWriter(dst)
+	case "deflate":
+		h.Set("Content-Encoding", "deflate")
+		return zlib.NewWriter(dst)
+	}
+
+	return nil
+}
+
+// compressible reports whether bodies of the media type are text-like and
+// shrink when compressed.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	if strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml",
+		"application/x-www-form-urlencoded", "image/svg+xml":
+		return true
+	}
+
+	return false
+}
+
+// negotiate picks the coding to compress with from the values of
+// Accept-Encoding: the supported coding with the highest q-value, gzip on a
+// tie, or "" if neither is acceptable. A coding not listed gets the q-value
+// of "*", if present.
+func negotiate(acceptEncoding []string) string {
+	qValues := map[string]float64{}
+	for _, value := range acceptEncoding {
+		for _, element := range strings.Split(value, ",") {
+			coding, q, ok := parseCoding(element)
+			if ok {
+				qValues[coding] = q
+			}
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, ok := qValues[coding]
+		if !ok {
+			q = qValues["*"]
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+
+	return best
+}
+
+// parseCoding parses a single element of Accept-Encoding, such as
+// "gzip;q=0.8", into its lower-cased coding and q-value.
+func parseCoding(element string) (string, float64, bool) {
+	coding, params, _ := strings.Cut(element, ";")
+	coding = strings.ToLower(strings.TrimSpace(coding))
+	if coding == "x-gzip" {
+		coding = "gzip"
+	}
+	if !headers.IsToken(coding) {
+		return "", 0, false
+	}
+
+	q := 1.0
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return "", 0, false
+		}
+		q = parsed
+	}
+
+	return coding, q, true
+}
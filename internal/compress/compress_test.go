@@ -0,0 +1,125 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"httpfromtcp/internal/servertest"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve sends a GET request with acceptEncoding through Middleware(handler)
+// and parses what it wrote back
+func serve(t *testing.T, handler server.Handler, acceptEncoding string) (*http.Response, string) {
+	t.Helper()
+
+	raw := "GET / HTTP/1.1\r\nHost: localhost:42069\r\n"
+	if acceptEncoding != "" {
+		raw += "Accept-Encoding: " + acceptEncoding + "\r\n"
+	}
+
+	return servertest.Serve(t, Middleware(handler), raw+"\r\n")
+}
+
+func textHandler(contentType, body string) server.Handler {
+	return func(w *response.Writer, r *request.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(body))
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	for acceptEncoding, want := range map[string]string{
+		"":                            "",
+		"gzip":                        "gzip",
+		"deflate":                     "deflate",
+		"deflate, gzip":               "gzip",
+		"gzip;q=0.5, deflate":         "deflate",
+		"GZIP;Q=0.8, deflate;q=0.9":   "deflate",
+		"x-gzip":                      "gzip",
+		"br":                          "",
+		"*":                           "gzip",
+		"*;q=0.5, gzip;q=0":           "deflate",
+		"gzip;q=0, deflate;q=0":       "",
+		"identity":                    "",
+		"gzip;q=2, deflate":           "deflate",
+		"gzip;q=abc":                  "",
+		"br, gzip;q=0.1, deflate;q=0": "gzip",
+	} {
+		var values []string
+		if acceptEncoding != "" {
+			values = []string{acceptEncoding}
+		}
+		assert.Equal(t, want, negotiate(values), acceptEncoding)
+	}
+
+	// Test: Values from repeated fields are combined
+	assert.Equal(t, "deflate", negotiate([]string{"gzip;q=0.1", "deflate"}))
+}
+
+func TestMiddleware(t *testing.T) {
+	body := strings.Repeat("<p>Your request was an absolute banger.</p>\n", 100)
+
+	// Test: gzip
+	res, encoded := serve(t, textHandler("text/html", body), "gzip, deflate")
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, int64(-1), res.ContentLength)
+	zr, err := gzip.NewReader(strings.NewReader(encoded))
+	require.NoError(t, err)
+	decoded, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(decoded))
+
+	// Test: deflate
+	res, encoded = serve(t, textHandler("application/json; charset=utf-8", body), "deflate")
+	assert.Equal(t, "deflate", res.Header.Get("Content-Encoding"))
+	zr2, err := zlib.NewReader(strings.NewReader(encoded))
+	require.NoError(t, err)
+	decoded, err = io.ReadAll(zr2)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(decoded))
+
+	// Test: Client without Accept-Encoding still gets Vary
+	res, raw := serve(t, textHandler("text/html", "hello"), "")
+	assert.Equal(t, "", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+	assert.Equal(t, int64(5), res.ContentLength)
+	assert.Equal(t, "hello", raw)
+
+	// Test: Already-compressed types are not compressed
+	video := string([]byte{0x00, 0x00, 0x00, 0x18, 'f', 't', 'y', 'p'})
+	res, raw = serve(t, func(w *response.Writer, r *request.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Header().Set("Content-Length", "8")
+		w.Write([]byte(video))
+	}, "gzip")
+	assert.Equal(t, "", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, "", res.Header.Get("Vary"))
+	assert.Equal(t, int64(8), res.ContentLength)
+	assert.Equal(t, video, raw)
+
+	// Test: Existing Content-Encoding is kept
+	res, _ = serve(t, func(w *response.Writer, r *request.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "br")
+		w.Write([]byte("already"))
+	}, "gzip")
+	assert.Equal(t, "br", res.Header.Get("Content-Encoding"))
+	assert.Equal(t, int64(7), res.ContentLength)
+
+	// Test: Bodyless statuses are not compressed
+	res, _ = serve(t, func(w *response.Writer, r *request.Request) {
+		w.WriteHeader(response.StatusNoContent)
+	}, "gzip")
+	assert.Equal(t, "", res.Header.Get("Content-Encoding"))
+}
@@ -2,6 +2,9 @@ package headers
 
 import (
 	"errors"
+	"fmt"
+	"iter"
+	"slices"
 	"strings"
 )
 
@@ -32,9 +35,132 @@ func checkHeaderKeyValidity(headerKey string) bool {
 	return true
 }
 
-type Headers map[string]string
+var (
+	ErrMalformedFieldLine = errors.New("malformed header field line")
+	ErrInvalidFieldName   = errors.New("invalid header field name")
+	ErrInvalidFieldValue  = errors.New("invalid header field value")
+)
+
+// FieldError reports a header field line rejected by validation. Err is
+// ErrInvalidFieldName or ErrInvalidFieldValue.
+type FieldError struct {
+	Name   string
+	Value  string
+	Err    error
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%v %q: %v", e.Err, e.Name, e.Reason)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// IsToken reports whether s is a token as defined by RFC 9110, the syntax
+// of field names and request methods.
+func IsToken(s string) bool {
+	return checkHeaderKeyValidity(strings.ToLower(s))
+}
+
+// ValidateFieldName checks that headerKey is a token as defined by RFC 9110.
+func ValidateFieldName(headerKey string) error {
+	if !IsToken(headerKey) {
+		return &FieldError{
+			Name:   headerKey,
+			Err:    ErrInvalidFieldName,
+			Reason: "not a valid token",
+		}
+	}
+
+	return nil
+}
+
+func describeControlChar(c byte) string {
+	switch c {
+	case '\r':
+		return "CR"
+	case '\n':
+		return "LF"
+	case 0:
+		return "NUL"
+	default:
+		return fmt.Sprintf("control character 0x%02X", c)
+	}
+}
+
+// ValidateField checks a field line against RFC 9110: the name has to be a
+// token and the value may only hold visible characters, spaces, tabs and
+// obs-text. Rejecting CR and LF in particular keeps a value from injecting
+// extra field lines or splitting the message.
+func ValidateField(headerKey, headerVal string) error {
+	err := ValidateFieldName(headerKey)
+	if err != nil {
+		return err
+	}
+
+	for i := range len(headerVal) {
+		c := headerVal[i]
+		if (c < ' ' && c != '\t') || c == 0x7F {
+			return &FieldError{
+				Name:   headerKey,
+				Value:  headerVal,
+				Err:    ErrInvalidFieldValue,
+				Reason: fmt.Sprintf("contains %v at index %v", describeControlChar(c), i),
+			}
+		}
+	}
+
+	return nil
+}
+
+type field struct {
+	name  string
+	key   string
+	value string
+}
+
+func newField(name, value string) field {
+	return field{
+		name:  name,
+		key:   CanonicalKey(name),
+		value: value,
+	}
+}
+
+// CanonicalKey returns the canonical form of a field name: the first letter
+// and every letter following a hyphen in upper case, the rest in lower case.
+// "content-length" becomes "Content-Length". Names are matched and written
+// in this form.
+func CanonicalKey(headerKey string) string {
+	canonical := []byte(headerKey)
+	upper := true
+	for i, c := range canonical {
+		if upper && 'a' <= c && c <= 'z' {
+			canonical[i] = c - 'a' + 'A'
+		} else if !upper && 'A' <= c && c <= 'Z' {
+			canonical[i] = c - 'A' + 'a'
+		}
+		upper = c == '-'
+	}
+
+	return string(canonical)
+}
+
+// Headers is an ordered list of header field lines. Names keep the casing
+// they were added or received with but are matched by their canonical form,
+// and a name that appears on several lines keeps each line as a separate
+// value.
+type Headers struct {
+	fields []field
+}
+
+func NewHeaders() *Headers {
+	return &Headers{}
+}
 
-func (h Headers) Parse(rawHeader string) (n int, done bool, err error) {
+func (h *Headers) Parse(rawHeader string) (n int, done bool, err error) {
 	crlfIDX := strings.Index(rawHeader, crlf)
 	if crlfIDX == -1 {
 		return 0, false, nil
@@ -46,33 +172,139 @@ func (h Headers) Parse(rawHeader string) (n int, done bool, err error) {
 	header := rawHeader[:crlfIDX]
 	headerKey, headerVal, ok := strings.Cut(strings.TrimSpace(header), ":")
 	if !ok {
-		return 0, false, errors.New("invalid header")
+		return 0, false, fmt.Errorf("%w: missing colon in %q", ErrMalformedFieldLine, header)
 	}
 
-	headerKey = strings.ToLower(headerKey)
-	if !checkHeaderKeyValidity(headerKey) {
-		return 0, false, errors.New("invalid header key")
+	headerVal = strings.TrimSpace(headerVal)
+	err = ValidateField(headerKey, headerVal)
+	if err != nil {
+		return 0, false, err
 	}
 
-	val, ok := h[headerKey]
-	headerVal = strings.TrimSpace(headerVal)
-	if ok {
-		if val == headerVal {
-			h[headerKey] = headerVal
-		} else {
-			h[headerKey] = val + ", " + headerVal
+	h.Add(headerKey, headerVal)
+
+	return len(header + crlf), false, nil
+}
+
+// Get returns the first value of the named field, or "" if there is none.
+func (h *Headers) Get(headerKey string) string {
+	key := CanonicalKey(headerKey)
+	for _, f := range h.fields {
+		if f.key == key {
+			return f.value
 		}
-	} else {
-		h[headerKey] = headerVal
 	}
 
-	return len(header + crlf), false, nil
+	return ""
+}
+
+// Values returns every value of the named field in the order received.
+func (h *Headers) Values(headerKey string) []string {
+	key := CanonicalKey(headerKey)
+	values := []string{}
+	for _, f := range h.fields {
+		if f.key == key {
+			values = append(values, f.value)
+		}
+	}
+
+	return values
+}
+
+func (h *Headers) Has(headerKey string) bool {
+	key := CanonicalKey(headerKey)
+	for _, f := range h.fields {
+		if f.key == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Add appends a field line, keeping any existing lines with the same name.
+func (h *Headers) Add(headerKey, headerVal string) {
+	h.fields = append(h.fields, newField(headerKey, headerVal))
+}
+
+// Set replaces every line of the named field with a single one. The field
+// keeps the position of its first line, or is appended if it is new.
+func (h *Headers) Set(headerKey, headerVal string) {
+	key := CanonicalKey(headerKey)
+	for i, f := range h.fields {
+		if f.key == key {
+			h.fields[i] = newField(headerKey, headerVal)
+			h.delFrom(i+1, headerKey)
+			return
+		}
+	}
+
+	h.Add(headerKey, headerVal)
+}
+
+func (h *Headers) Del(headerKey string) {
+	h.delFrom(0, headerKey)
+}
+
+func (h *Headers) delFrom(start int, headerKey string) {
+	key := CanonicalKey(headerKey)
+	kept := h.fields[:start]
+	for _, f := range h.fields[start:] {
+		if f.key != key {
+			kept = append(kept, f)
+		}
+	}
+	h.fields = kept
+}
+
+// Len returns the number of field lines.
+func (h *Headers) Len() int {
+	return len(h.fields)
+}
+
+// All iterates over every field line in order, yielding names as they were
+// added or received. Use CanonicalKey to get the form they are written in.
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for _, f := range h.fields {
+			if !yield(f.name, f.value) {
+				return
+			}
+		}
+	}
+}
+
+func (h *Headers) Clone() *Headers {
+	return &Headers{
+		fields: slices.Clone(h.fields),
+	}
+}
+
+// Sort orders the field lines by canonical name. Lines with the same name
+// keep their relative order, since it is significant for repeated fields.
+func (h *Headers) Sort() {
+	slices.SortStableFunc(h.fields, func(a, b field) int {
+		return strings.Compare(a.key, b.key)
+	})
 }
 
-func (h Headers) Get(headerKey string) string {
-	return h[strings.ToLower(headerKey)]
+// forbiddenTrailers are the fields RFC 9110 section 6.5.1 rules out as
+// trailers, because recipients need them before the content to frame,
+// route, authenticate or interpret the message.
+var forbiddenTrailers = map[string]struct{}{
+	"Age": {}, "Authorization": {}, "Cache-Control": {}, "Connection": {},
+	"Content-Encoding": {}, "Content-Length": {}, "Content-Range": {}, "Content-Type": {},
+	"Date": {}, "Expect": {}, "Expires": {}, "Host": {}, "If-Match": {},
+	"If-Modified-Since": {}, "If-None-Match": {}, "If-Range": {}, "If-Unmodified-Since": {},
+	"Keep-Alive": {}, "Location": {}, "Max-Forwards": {}, "Pragma": {},
+	"Proxy-Authenticate": {}, "Proxy-Authorization": {}, "Range": {}, "Retry-After": {},
+	"Set-Cookie": {}, "Te": {}, "Trailer": {}, "Transfer-Encoding": {}, "Upgrade": {},
+	"Vary": {}, "Warning": {}, "Www-Authenticate": {},
 }
 
-func NewHeaders() Headers {
-	return make(Headers)
+// IsForbiddenTrailer reports whether the named field may not be sent as a
+// trailer.
+func IsForbiddenTrailer(headerKey string) bool {
+	_, ok := forbiddenTrailers[CanonicalKey(headerKey)]
+	return ok
 }
@@ -14,7 +14,7 @@ func TestParse(t *testing.T) {
 	n, done, err := headers.Parse(string(data))
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
@@ -24,7 +24,7 @@ func TestParse(t *testing.T) {
 	n, done, err = headers.Parse(string(data))
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, 25, n)
 	assert.False(t, done)
 
@@ -34,7 +34,7 @@ func TestParse(t *testing.T) {
 	n, done, err = headers.Parse(string(data))
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, "localhost:42069", headers.Get("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
@@ -44,7 +44,7 @@ func TestParse(t *testing.T) {
 	n, done, err = headers.Parse(string(data))
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "lane-loves-go", headers["set-person"])
+	assert.Equal(t, "lane-loves-go", headers.Get("set-person"))
 	assert.Equal(t, 27, n)
 	assert.False(t, done)
 
@@ -52,7 +52,7 @@ func TestParse(t *testing.T) {
 	n, done, err = headers.Parse(string(data))
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "lane-loves-go, prime-loves-zig", headers["set-person"])
+	assert.Equal(t, []string{"lane-loves-go", "prime-loves-zig"}, headers.Values("set-person"))
 	assert.Equal(t, 29, n)
 	assert.False(t, done)
 
@@ -60,7 +60,7 @@ func TestParse(t *testing.T) {
 	n, done, err = headers.Parse(string(data))
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "lane-loves-go, prime-loves-zig, tj-loves-ocaml", headers["set-person"])
+	assert.Equal(t, []string{"lane-loves-go", "prime-loves-zig", "tj-loves-ocaml"}, headers.Values("set-person"))
 	assert.Equal(t, 28, n)
 	assert.False(t, done)
 
@@ -89,3 +89,139 @@ func TestParse(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 }
+
+func TestHeadersFields(t *testing.T) {
+	// Test: Parsed fields keep order, casing and repeated lines
+	headers := NewHeaders()
+	data := "Host: localhost:42069\r\n" +
+		"Set-Cookie: a=1; Path=/\r\n" +
+		"X-Request-ID: abc\r\n" +
+		"set-cookie: b=2; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\n" +
+		"\r\n"
+	for {
+		n, done, err := headers.Parse(data)
+		require.NoError(t, err)
+		data = data[n:]
+		if done {
+			break
+		}
+	}
+	assert.Equal(t, 4, headers.Len())
+	assert.Equal(t, "a=1; Path=/", headers.Get("SET-COOKIE"))
+	assert.Equal(t, []string{"a=1; Path=/", "b=2; Expires=Wed, 21 Oct 2015 07:28:00 GMT"}, headers.Values("Set-Cookie"))
+	names := []string{}
+	for name := range headers.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"Host", "Set-Cookie", "X-Request-ID", "set-cookie"}, names)
+
+	// Test: Has
+	assert.True(t, headers.Has("x-request-id"))
+	assert.False(t, headers.Has("content-length"))
+	assert.Equal(t, []string{}, headers.Values("content-length"))
+	assert.Equal(t, "", headers.Get("content-length"))
+
+	// Test: Add appends another line
+	headers.Add("X-Request-ID", "def")
+	assert.Equal(t, []string{"abc", "def"}, headers.Values("x-request-id"))
+
+	// Test: Set replaces every line in place of the first one
+	headers.Set("set-cookie", "c=3")
+	assert.Equal(t, []string{"c=3"}, headers.Values("Set-Cookie"))
+	names = []string{}
+	for name, value := range headers.All() {
+		names = append(names, name+": "+value)
+	}
+	assert.Equal(t, []string{"Host: localhost:42069", "set-cookie: c=3", "X-Request-ID: abc", "X-Request-ID: def"}, names)
+
+	// Test: Set appends a new field
+	headers.Set("Content-Type", "text/plain")
+	assert.Equal(t, "text/plain", headers.Get("content-type"))
+	assert.Equal(t, 5, headers.Len())
+
+	// Test: Del removes every line
+	headers.Del("x-request-id")
+	assert.False(t, headers.Has("X-Request-ID"))
+	assert.Equal(t, 3, headers.Len())
+}
+
+func TestCanonicalKey(t *testing.T) {
+	assert.Equal(t, "Content-Length", CanonicalKey("content-length"))
+	assert.Equal(t, "Content-Length", CanonicalKey("CONTENT-LENGTH"))
+	assert.Equal(t, "X-Request-Id", CanonicalKey("x-request-ID"))
+	assert.Equal(t, "Host", CanonicalKey("host"))
+	assert.Equal(t, "X--Y", CanonicalKey("x--y"))
+	assert.Equal(t, "", CanonicalKey(""))
+
+	// Test: Fields are matched by their canonical form
+	headers := NewHeaders()
+	headers.Set("Content-Length", "5")
+	assert.Equal(t, "5", headers.Get("content-length"))
+	headers.Set("CONTENT-LENGTH", "6")
+	assert.Equal(t, []string{"6"}, headers.Values("Content-Length"))
+}
+
+func TestSort(t *testing.T) {
+	headers := NewHeaders()
+	headers.Add("x-b", "1")
+	headers.Add("Content-Type", "text/plain")
+	headers.Add("X-A", "2")
+	headers.Add("x-b", "3")
+	headers.Sort()
+
+	lines := []string{}
+	for name, value := range headers.All() {
+		lines = append(lines, CanonicalKey(name)+": "+value)
+	}
+	assert.Equal(t, []string{"Content-Type: text/plain", "X-A: 2", "X-B: 1", "X-B: 3"}, lines)
+}
+
+func TestFieldValidation(t *testing.T) {
+	// Test: Parse rejects control characters in values
+	for name, data := range map[string]string{
+		"CR":   "X-Test: a\rb\r\n\r\n",
+		"LF":   "X-Test: a\nb\r\n\r\n",
+		"NUL":  "X-Test: a\x00b\r\n\r\n",
+		"0x07": "X-Test: a\x07b\r\n\r\n",
+		"0x1B": "X-Test: \x1b[31mred\r\n\r\n",
+		"0x7F": "X-Test: a\x7fb\r\n\r\n",
+	} {
+		headers := NewHeaders()
+		n, done, err := headers.Parse(data)
+		require.Error(t, err, name)
+		assert.ErrorIs(t, err, ErrInvalidFieldValue, name)
+		var fieldErr *FieldError
+		require.ErrorAs(t, err, &fieldErr, name)
+		assert.Equal(t, "X-Test", fieldErr.Name)
+		assert.Contains(t, fieldErr.Error(), name)
+		assert.Equal(t, 0, n)
+		assert.False(t, done)
+		assert.Equal(t, 0, headers.Len())
+	}
+
+	// Test: Parse rejects invalid names with a typed error
+	headers := NewHeaders()
+	_, _, err := headers.Parse("H©st: localhost:42069\r\n\r\n")
+	assert.ErrorIs(t, err, ErrInvalidFieldName)
+
+	// Test: Tabs, spaces and obs-text are allowed in values
+	headers = NewHeaders()
+	_, _, err = headers.Parse("X-Test: a\tb c \xe9\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "a\tb c \xe9", headers.Get("x-test"))
+
+	// Test: ValidateField
+	require.NoError(t, ValidateField("Set-Cookie", "a=1; Path=/"))
+	assert.ErrorIs(t, ValidateField("X-Injected", "a\r\nSet-Cookie: evil=1"), ErrInvalidFieldValue)
+	assert.ErrorIs(t, ValidateField("X-Test\r\nEvil", "a"), ErrInvalidFieldName)
+	assert.ErrorIs(t, ValidateField("", "a"), ErrInvalidFieldName)
+}
+
+func TestForbiddenTrailers(t *testing.T) {
+	for _, name := range []string{"Content-Length", "transfer-encoding", "HOST", "Authorization", "Set-Cookie", "Trailer"} {
+		assert.True(t, IsForbiddenTrailer(name), name)
+	}
+	for _, name := range []string{"X-Checksum", "Server-Timing", "Digest"} {
+		assert.False(t, IsForbiddenTrailer(name), name)
+	}
+}
@@ -0,0 +1,175 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+)
+
+type bufReader struct {
+	src         io.Reader
+	buf         []byte
+	readToIndex int
+}
+
+func newBufReader(src io.Reader) *bufReader {
+	return &bufReader{
+		src: src,
+		buf: make([]byte, bufferSize),
+	}
+}
+
+func (br *bufReader) buffered() []byte {
+	return br.buf[:br.readToIndex]
+}
+
+func (br *bufReader) discard(n int) {
+	copy(br.buf, br.buf[n:br.readToIndex])
+	br.readToIndex -= n
+}
+
+func (br *bufReader) fill() error {
+	if br.readToIndex >= len(br.buf) {
+		newBuf := make([]byte, len(br.buf)*2)
+		copy(newBuf, br.buf)
+		br.buf = newBuf
+	}
+
+	n, err := br.src.Read(br.buf[br.readToIndex:])
+	br.readToIndex += n
+	if n > 0 {
+		return nil
+	}
+
+	return err
+}
+
+var ErrBodyNotDrained = errors.New("request body too large to drain")
+
+type body struct {
+	req    *Request
+	reader *bufReader
+	err    error
+	closed bool
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.closed {
+		return 0, errors.New("read on closed body")
+	}
+
+	n, err := b.read(p)
+	if err != nil {
+		b.err = err
+	}
+
+	return n, err
+}
+
+func (b *body) read(p []byte) (int, error) {
+	for {
+		switch b.req.state {
+		case requestStateDone:
+			return 0, io.EOF
+		case requestStateParsingBody, requestStateParsingChunkData:
+			return b.readData(p)
+		}
+
+		n, err := b.req.parse(b.reader.buffered())
+		if err != nil {
+			log.Printf("error parsing body: %v\n", err)
+			return 0, err
+		}
+
+		b.reader.discard(n)
+		if n > 0 {
+			continue
+		}
+
+		err = b.reader.fill()
+		if err == io.EOF {
+			return 0, fmt.Errorf("%w: incomplete chunked body", ErrMalformedChunk)
+		}
+		if err != nil {
+			log.Printf("error reading body: %v\n", err)
+			return 0, err
+		}
+	}
+}
+
+func (b *body) readData(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if int64(len(p)) > b.req.bytesRemaining {
+		p = p[:b.req.bytesRemaining]
+	}
+
+	n := 0
+	if buffered := b.reader.buffered(); len(buffered) > 0 {
+		n = copy(p, buffered)
+		b.reader.discard(n)
+	} else {
+		var err error
+		n, err = b.reader.src.Read(p)
+		if n == 0 && err == io.EOF {
+			if b.req.isParsingChunkedBody() {
+				return 0, fmt.Errorf("%w: incomplete chunked body", ErrMalformedChunk)
+			}
+			return 0, ErrLengthMismatch
+		}
+		if n == 0 && err != nil {
+			log.Printf("error reading body: %v\n", err)
+			return 0, err
+		}
+	}
+
+	b.req.bytesRemaining -= int64(n)
+	if b.req.bytesRemaining == 0 {
+		if b.req.state == requestStateParsingChunkData {
+			b.req.state = requestStateParsingChunkDataEnd
+		} else {
+			b.req.state = requestStateDone
+		}
+	}
+
+	return n, nil
+}
+
+// drain discards the rest of the body, even if it was closed, giving up with
+// ErrBodyNotDrained after limit bytes. A limit of 0 means no limit.
+func (b *body) drain(limit int64) error {
+	if b.err == io.EOF {
+		return nil
+	}
+	if b.err != nil {
+		return b.err
+	}
+
+	buf := make([]byte, 512)
+	drained := int64(0)
+	for {
+		n, err := b.read(buf)
+		drained += int64(n)
+		if err == io.EOF {
+			b.err = err
+			return nil
+		}
+		if err != nil {
+			b.err = err
+			return err
+		}
+		if exceeds(drained, limit) {
+			return ErrBodyNotDrained
+		}
+	}
+}
+
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
@@ -0,0 +1,165 @@
+package request
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	ErrUnsupportedContentEncoding = errors.New("unsupported content encoding")
+	ErrInvalidContentEncoding     = errors.New("invalid content encoding")
+)
+
+// DefaultMaxDecodedBodyBytes bounds decoded bodies when Limits sets no
+// MaxBodyBytes, as a few kilobytes of gzip can expand to gigabytes.
+const DefaultMaxDecodedBodyBytes = 10 << 20
+
+// contentCodings returns the codings listed in Content-Encoding in the order
+// they were applied, rejecting codings that cannot be decoded.
+func contentCodings(values []string) ([]string, error) {
+	codings := []string{}
+	for _, coding := range listElements(values) {
+		switch strings.ToLower(coding) {
+		case "identity":
+		case "gzip", "x-gzip":
+			codings = append(codings, "gzip")
+		case "deflate":
+			codings = append(codings, "deflate")
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrUnsupportedContentEncoding, coding)
+		}
+	}
+
+	return codings, nil
+}
+
+// decodeBody replaces Body with one that undoes the Content-Encoding of the
+// request. Content-Encoding and Content-Length are removed from Headers, as
+// they no longer describe the body.
+func (r *Request) decodeBody() error {
+	if !r.Headers.Has("content-encoding") {
+		return nil
+	}
+
+	codings, err := contentCodings(r.Headers.Values("content-encoding"))
+	if err != nil {
+		return err
+	}
+
+	r.Headers.Del("Content-Encoding")
+	r.Headers.Del("Content-Length")
+	if len(codings) == 0 || r.state == requestStateDone {
+		return nil
+	}
+
+	limit := r.limits.MaxBodyBytes
+	if limit <= 0 {
+		limit = DefaultMaxDecodedBodyBytes
+	}
+	r.Body = &decodedBody{
+		raw:     &rawReader{src: r.Body},
+		codings: codings,
+		limit:   limit,
+	}
+
+	return nil
+}
+
+// rawReader keeps the error the encoded body failed with, to tell it apart
+// from errors in the encoded data.
+type rawReader struct {
+	src io.ReadCloser
+	err error
+}
+
+func (rr *rawReader) Read(p []byte) (int, error) {
+	n, err := rr.src.Read(p)
+	if err != nil {
+		rr.err = err
+	}
+
+	return n, err
+}
+
+// decodedBody decodes the body as it is read. The decoded size counts
+// against the body limit, or DefaultMaxDecodedBodyBytes without one, so a
+// small body cannot expand without bound.
+type decodedBody struct {
+	raw     *rawReader
+	codings []string
+	limit   int64
+	reader  io.Reader
+	n       int64
+	err     error
+}
+
+func (db *decodedBody) Read(p []byte) (int, error) {
+	if db.err != nil {
+		return 0, db.err
+	}
+
+	n, err := db.read(p)
+	if err != nil {
+		db.err = err
+	}
+
+	return n, err
+}
+
+func (db *decodedBody) read(p []byte) (int, error) {
+	if db.reader == nil {
+		reader, err := newDecoder(db.raw, db.codings)
+		if err != nil {
+			return 0, db.decodeError(err)
+		}
+		db.reader = reader
+	}
+
+	n, err := db.reader.Read(p)
+	db.n += int64(n)
+	if exceeds(db.n, db.limit) {
+		return 0, fmt.Errorf("%w: decoded body over %v bytes", ErrBodyTooLarge, db.limit)
+	}
+	if err != nil && err != io.EOF {
+		return n, db.decodeError(err)
+	}
+
+	return n, err
+}
+
+// decodeError passes errors reading the encoded body on and reports the
+// others as invalid encoded data.
+func (db *decodedBody) decodeError(err error) error {
+	if db.raw.err != nil && db.raw.err != io.EOF {
+		return db.raw.err
+	}
+
+	return fmt.Errorf("%w: %v", ErrInvalidContentEncoding, err)
+}
+
+func (db *decodedBody) Close() error {
+	return db.raw.src.Close()
+}
+
+// newDecoder stacks decoders over src, undoing the last coding applied first.
+func newDecoder(src io.Reader, codings []string) (io.Reader, error) {
+	reader := src
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		switch codings[i] {
+		case "gzip":
+			reader, err = gzip.NewReader(reader)
+		case "deflate":
+			reader, err = zlib.NewReader(reader)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return reader, nil
+}
@@ -0,0 +1,66 @@
+package request
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+func isHostChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	default:
+		return strings.IndexByte("-._~%!$&'()*+,;=", c) != -1
+	}
+}
+
+// isValidHost reports whether host matches the Host field syntax of RFC 9110:
+// a registered name, an IPv4 address or a bracketed IPv6 address, optionally
+// followed by a port.
+func isValidHost(host string) bool {
+	name, port := host, ""
+	if strings.HasPrefix(host, "[") {
+		end := strings.Index(host, "]")
+		if end == -1 || net.ParseIP(host[1:end]) == nil {
+			return false
+		}
+		name, port = "", host[end+1:]
+		if port != "" && port[0] != ':' {
+			return false
+		}
+	} else if i := strings.IndexByte(host, ':'); i != -1 {
+		name, port = host[:i], host[i:]
+	}
+
+	for i := range len(name) {
+		if !isHostChar(name[i]) {
+			return false
+		}
+	}
+	for i := 1; i < len(port); i++ {
+		if !isDigit(port[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validateHost checks the Host header. HTTP/1.1 requests have to carry
+// exactly one, HTTP/1.0 requests at most one.
+func (r *Request) validateHost() error {
+	hosts := r.Headers.Values("host")
+	switch {
+	case len(hosts) == 0 && r.RequestLine.HttpVersion == "1.0":
+		return nil
+	case len(hosts) == 0:
+		return fmt.Errorf("%w: missing Host header", ErrInvalidHost)
+	case len(hosts) > 1:
+		return fmt.Errorf("%w: %v Host headers", ErrInvalidHost, len(hosts))
+	case !isValidHost(hosts[0]):
+		return fmt.Errorf("%w: %q", ErrInvalidHost, hosts[0])
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package request
+
+import "errors"
+
+var (
+	ErrRequestLineTooLong = errors.New("request line too long")
+	ErrHeaderTooLarge     = errors.New("request header fields too large")
+	ErrBodyTooLarge       = errors.New("request body too large")
+)
+
+// maxChunkSizeLineBytes bounds a chunk-size line including its extensions.
+const maxChunkSizeLineBytes = 4096
+
+// Limits bounds the size of the requests a Parser accepts. They are checked
+// as bytes arrive, so an oversized request is rejected without buffering
+// it. A zero field means no limit.
+type Limits struct {
+	// MaxRequestLineBytes bounds the request line, excluding its CRLF.
+	MaxRequestLineBytes int
+	// MaxHeaderBytes bounds the header section, and separately the trailer
+	// section of a chunked body, including line endings.
+	MaxHeaderBytes int
+	// MaxHeaderCount bounds the number of header field lines, and
+	// separately the number of trailer field lines.
+	MaxHeaderCount int
+	// MaxBodyBytes bounds the request body after any chunked framing has
+	// been removed.
+	MaxBodyBytes int64
+}
+
+var DefaultLimits = Limits{
+	MaxRequestLineBytes: 8 << 10,
+	MaxHeaderBytes:      1 << 20,
+	MaxHeaderCount:      100,
+}
+
+func exceeds[T int | int64](n, limit T) bool {
+	return limit > 0 && n > limit
+}
+
+func (r *Request) checkRequestLine(n int) error {
+	if exceeds(n, r.limits.MaxRequestLineBytes) {
+		return ErrRequestLineTooLong
+	}
+
+	return nil
+}
+
+// checkFieldLine accounts for a field line of n bytes parsed out of
+// buffered bytes. n is 0 while the line is still incomplete, in which case
+// the buffered bytes count towards the limit.
+func (r *Request) checkFieldLine(buffered, n int, done bool) error {
+	if done {
+		return nil
+	}
+	if n == 0 {
+		if exceeds(r.fieldBytes+buffered, r.limits.MaxHeaderBytes) {
+			return ErrHeaderTooLarge
+		}
+		return nil
+	}
+
+	r.fieldBytes += n
+	if exceeds(r.fieldBytes, r.limits.MaxHeaderBytes) {
+		return ErrHeaderTooLarge
+	}
+	r.fieldCount++
+	if exceeds(r.fieldCount, r.limits.MaxHeaderCount) {
+		return ErrHeaderTooLarge
+	}
+
+	return nil
+}
+
+func (r *Request) checkBody(n int64) error {
+	if exceeds(r.bodyBytes+n, r.limits.MaxBodyBytes) {
+		return ErrBodyTooLarge
+	}
+
+	r.bodyBytes += n
+
+	return nil
+}
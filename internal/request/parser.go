@@ -0,0 +1,169 @@
+package request
+
+import (
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"io"
+	"log"
+)
+
+// Parser reads consecutive requests from a single connection. Bytes read
+// past the end of one request are kept and used for the next one, so
+// pipelined requests are never lost.
+type Parser struct {
+	// Limits bounds the requests the parser accepts. NewParser sets it to
+	// DefaultLimits.
+	Limits Limits
+	// DecodeContent has Request.Body decode gzip and deflate bodies, as
+	// described by Content-Encoding. The decoded size is bounded by
+	// MaxBodyBytes, or DefaultMaxDecodedBodyBytes if that is not set.
+	// Requests with other codings fail with ErrUnsupportedContentEncoding.
+	DecodeContent bool
+
+	reader *bufReader
+	body   *body
+}
+
+func NewParser(reader io.Reader) *Parser {
+	return &Parser{
+		Limits: DefaultLimits,
+		reader: newBufReader(reader),
+	}
+}
+
+func (p *Parser) drainPrevious() error {
+	if p.body == nil {
+		return nil
+	}
+
+	err := p.body.drain(0)
+	if err != nil {
+		log.Printf("error draining previous request body: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// DrainBody discards what is left of the last request's body so the next
+// request can be read, giving up with ErrBodyNotDrained after limit bytes.
+// It works whether or not Request.Body was closed.
+func (p *Parser) DrainBody(limit int64) error {
+	if p.body == nil {
+		return nil
+	}
+
+	return p.body.drain(limit)
+}
+
+// UnreadBody returns how many bytes of the last request's body are left to
+// be read, or -1 if that is not known, as with an unfinished chunked body, or
+// the body failed.
+func (p *Parser) UnreadBody() int64 {
+	if p.body == nil {
+		return 0
+	}
+	if p.body.err != nil && p.body.err != io.EOF {
+		return -1
+	}
+
+	switch p.body.req.state {
+	case requestStateDone:
+		return 0
+	case requestStateParsingBody:
+		return p.body.req.bytesRemaining
+	default:
+		return -1
+	}
+}
+
+// WaitForRequest blocks until the first bytes of the next request have been
+// received, which lets callers tell an idle connection from a slow request.
+// It returns io.EOF if the connection is closed first.
+func (p *Parser) WaitForRequest() error {
+	err := p.drainPrevious()
+	if err != nil {
+		return err
+	}
+
+	for len(p.reader.buffered()) == 0 {
+		err = p.reader.fill()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Next parses the request line and headers of the next request and returns
+// as soon as the header section is complete. The body is left on the
+// connection and pulled from it lazily through Request.Body. Whatever is left
+// of the previous request's body is discarded first. If the connection is
+// closed before any bytes of a new request arrive, io.EOF is returned.
+func (p *Parser) Next() (*Request, error) {
+	err := p.drainPrevious()
+	if err != nil {
+		return nil, err
+	}
+
+	req := &Request{
+		Headers:  headers.NewHeaders(),
+		Trailers: headers.NewHeaders(),
+		state:    requestStateInitialized,
+		limits:   p.Limits,
+	}
+
+	for req.isParsingHead() {
+		n, err := req.parse(p.reader.buffered())
+		if err != nil {
+			log.Printf("error parsing request: %v\n", err)
+			return nil, err
+		}
+
+		p.reader.discard(n)
+		if !req.isParsingHead() {
+			break
+		}
+
+		err = p.reader.fill()
+		if err == io.EOF && req.state == requestStateInitialized {
+			if len(p.reader.buffered()) == 0 {
+				return nil, io.EOF
+			}
+			return nil, fmt.Errorf("%w: connection closed in the request line", ErrIncompleteRequest)
+		}
+		if err == io.EOF && len(p.reader.buffered()) > 0 {
+			return nil, fmt.Errorf("%w: connection closed in a header line", ErrIncompleteRequest)
+		}
+		if err == io.EOF {
+			// the header section ends with the connection, but the body
+			// still has to be framed like any other
+			err = req.endHeaders()
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		if err != nil {
+			log.Printf("error reading request: %v\n", err)
+			return nil, err
+		}
+	}
+
+	p.body = &body{
+		req:    req,
+		reader: p.reader,
+	}
+	req.Body = p.body
+
+	if p.DecodeContent {
+		err = req.decodeBody()
+		if err != nil {
+			log.Printf("error decoding request body: %v\n", err)
+			return nil, err
+		}
+	}
+
+	return req, nil
+}
@@ -2,9 +2,11 @@ package request
 
 import (
 	"errors"
+	"fmt"
 	"httpfromtcp/internal/headers"
 	"io"
 	"log"
+	"net/url"
 	"strconv"
 	"strings"
 )
@@ -12,6 +14,22 @@ import (
 const bufferSize = 8
 const crlf = "\r\n"
 
+// Errors returned for requests that do not follow the HTTP/1.1 syntax. They
+// are wrapped with the details of what was wrong, so use errors.Is to test
+// for them.
+var (
+	ErrMalformedRequestLine    = errors.New("malformed request line")
+	ErrUnsupportedVersion      = errors.New("unsupported http version")
+	ErrInvalidRequestTarget    = errors.New("invalid request target")
+	ErrInvalidHost             = errors.New("invalid host header")
+	ErrIncompleteRequest       = errors.New("incomplete request")
+	ErrInvalidContentLength    = errors.New("invalid content length")
+	ErrInvalidTransferEncoding = errors.New("invalid transfer encoding")
+	ErrAmbiguousFraming        = errors.New("ambiguous message framing")
+	ErrLengthMismatch          = errors.New("request body shorter than its content length")
+	ErrMalformedChunk          = errors.New("malformed chunked body")
+)
+
 type RequestLine struct {
 	Method        string
 	RequestTarget string
@@ -24,16 +42,167 @@ const (
 	requestStateInitialized requestState = iota
 	requestStateParsingHeaders
 	requestStateParsingBody
+	requestStateParsingChunkSize
+	requestStateParsingChunkData
+	requestStateParsingChunkDataEnd
+	requestStateParsingTrailers
 	requestStateDone
 )
 
 type Request struct {
 	RequestLine RequestLine
-	Headers     headers.Headers
-	Body        []byte
-	state       requestState
+	// URL is the parsed request target. Path holds the decoded path and
+	// EscapedPath the path as it was sent; Query parses the query string.
+	// CONNECT requests only have a Host and OPTIONS * requests a Path of "*".
+	URL     *url.URL
+	Headers *headers.Headers
+	// Body streams the request body from the connection as it is read.
+	// It always returns io.EOF once the body has been fully consumed.
+	Body io.ReadCloser
+	// Trailers holds the trailer fields of a chunked body. They are only
+	// populated once Body has been read to io.EOF.
+	Trailers       *headers.Headers
+	state          requestState
+	bytesRemaining int64
+	pathValues     map[string]string
+	limits         Limits
+	fieldBytes     int
+	fieldCount     int
+	bodyBytes      int64
+}
+
+// listElements splits the comma-separated list values of a field, dropping
+// the optional whitespace around elements and empty elements.
+func listElements(values []string) []string {
+	elements := []string{}
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			element = strings.Trim(element, " \t")
+			if element != "" {
+				elements = append(elements, element)
+			}
+		}
+	}
+
+	return elements
+}
+
+// checkTransferEncoding accepts chunked as the only transfer coding. A body
+// with any other coding cannot be decoded, and so its length cannot be
+// known, which is not something to guess at when the request may have come
+// through a proxy that framed it differently.
+func checkTransferEncoding(h *headers.Headers) error {
+	codings := listElements(h.Values("transfer-encoding"))
+	if len(codings) != 1 || !strings.EqualFold(codings[0], "chunked") {
+		return fmt.Errorf("%w: %q", ErrInvalidTransferEncoding, strings.Join(h.Values("transfer-encoding"), ", "))
+	}
+
+	return nil
+}
+
+func isDigits(s string) bool {
+	for i := range len(s) {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+
+	return s != ""
 }
 
+// parseContentLength parses the Content-Length field. Repeated values, on
+// separate lines or in a list, are only accepted if they are identical.
+func parseContentLength(h *headers.Headers) (int64, error) {
+	if !h.Has("content-length") {
+		return 0, nil
+	}
+
+	contentLengths := listElements(h.Values("content-length"))
+	if len(contentLengths) == 0 {
+		return 0, fmt.Errorf("%w: empty value", ErrInvalidContentLength)
+	}
+
+	contentLengthVal := contentLengths[0]
+	for _, v := range contentLengths[1:] {
+		if v != contentLengthVal {
+			return 0, fmt.Errorf("%w: differing values %q and %q", ErrInvalidContentLength, contentLengthVal, v)
+		}
+	}
+
+	// ParseInt alone would accept a sign
+	if !isDigits(contentLengthVal) {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, contentLengthVal)
+	}
+	contentLength, err := strconv.ParseInt(contentLengthVal, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidContentLength, contentLengthVal)
+	}
+
+	return contentLength, nil
+}
+
+func parseChunkSize(rawChunkSizeLine string) (int64, int, error) {
+	crlfIDX := strings.Index(rawChunkSizeLine, crlf)
+	if crlfIDX == -1 {
+		return 0, 0, nil
+	}
+
+	chunkSizeLine := rawChunkSizeLine[:crlfIDX]
+	if strings.ContainsAny(chunkSizeLine, "\r\n") {
+		return 0, 0, fmt.Errorf("%w: bare CR or LF in chunk size line", ErrMalformedChunk)
+	}
+	chunkSize, _, _ := strings.Cut(chunkSizeLine, ";")
+	chunkSize = strings.TrimRight(chunkSize, " \t")
+	if chunkSize == "" {
+		return 0, 0, fmt.Errorf("%w: missing chunk size", ErrMalformedChunk)
+	}
+
+	size, err := strconv.ParseUint(chunkSize, 16, 63)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: invalid chunk size %q", ErrMalformedChunk, chunkSize)
+	}
+
+	return int64(size), len(chunkSizeLine + crlf), nil
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// parseHTTPVersion parses an HTTP-version as defined by RFC 9112, "HTTP/"
+// followed by a single digit major and minor version, and returns the
+// version without the prefix.
+func parseHTTPVersion(protocol string) (string, bool) {
+	version, ok := strings.CutPrefix(protocol, "HTTP/")
+	if !ok || len(version) != 3 {
+		return "", false
+	}
+	if !isDigit(version[0]) || version[1] != '.' || !isDigit(version[2]) {
+		return "", false
+	}
+
+	return version, true
+}
+
+// isValidRequestTarget reports whether target is non-empty and only holds
+// visible ASCII characters, which every form of request-target is made of.
+func isValidRequestTarget(target string) bool {
+	if target == "" {
+		return false
+	}
+
+	for i := range len(target) {
+		if target[i] <= ' ' || target[i] >= 0x7F {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseRequestLine parses a request line as defined by RFC 9112: method,
+// request-target and HTTP-version separated by exactly one space each.
+// Methods are matched case-sensitively and have to be upper case.
 func parseRequestLine(rawRequestLine string) (RequestLine, int, error) {
 	crlfIDX := strings.Index(rawRequestLine, crlf)
 	if crlfIDX == -1 {
@@ -42,20 +211,26 @@ func parseRequestLine(rawRequestLine string) (RequestLine, int, error) {
 
 	requestLine := rawRequestLine[:crlfIDX]
 	requestLineSlice := strings.Split(requestLine, " ")
+	if len(requestLineSlice) != 3 {
+		return RequestLine{}, 0, fmt.Errorf("%w: expected 3 parts separated by single spaces in %q", ErrMalformedRequestLine, requestLine)
+	}
 
 	method := requestLineSlice[0]
-	if method != strings.ToUpper(method) {
-		return RequestLine{}, 0, errors.New("invalid method")
+	if !headers.IsToken(method) || method != strings.ToUpper(method) {
+		return RequestLine{}, 0, fmt.Errorf("%w: invalid method %q", ErrMalformedRequestLine, method)
 	}
 
 	requestTarget := requestLineSlice[1]
-	if requestTarget == "" {
-		return RequestLine{}, 0, errors.New("invalid request target")
+	if !isValidRequestTarget(requestTarget) {
+		return RequestLine{}, 0, fmt.Errorf("%w: invalid request target %q", ErrMalformedRequestLine, requestTarget)
 	}
 
-	httpVersion := strings.Split(requestLineSlice[2], "/")[1]
-	if httpVersion != "1.1" {
-		return RequestLine{}, 0, errors.New("invalid http version")
+	httpVersion, ok := parseHTTPVersion(requestLineSlice[2])
+	if !ok {
+		return RequestLine{}, 0, fmt.Errorf("%w: invalid http version %q", ErrMalformedRequestLine, requestLineSlice[2])
+	}
+	if httpVersion != "1.1" && httpVersion != "1.0" {
+		return RequestLine{}, 0, fmt.Errorf("%w: %q", ErrUnsupportedVersion, httpVersion)
 	}
 
 	return RequestLine{
@@ -76,49 +251,115 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			return 0, err
 		}
 		if n == 0 {
-			return 0, nil
+			// a trailing CR may be the start of the CRLF
+			return 0, r.checkRequestLine(len(strings.TrimSuffix(string(data), "\r")))
+		}
+
+		err = r.checkRequestLine(n - len(crlf))
+		if err != nil {
+			return 0, err
+		}
+
+		u, err := parseRequestTarget(reqLine.Method, reqLine.RequestTarget)
+		if err != nil {
+			log.Printf("error parsing request target: %v\n", err)
+			return 0, err
 		}
 
 		r.RequestLine = reqLine
+		r.URL = u
 		r.state = requestStateParsingHeaders
 
 		return n, nil
 	case requestStateParsingHeaders:
+		err := checkFolding(data)
+		if err != nil {
+			return 0, err
+		}
+
 		n, done, err := r.Headers.Parse(string(data))
 		if err != nil {
 			log.Printf("error parsing headers: %v\n", err)
 			return 0, err
 		}
+
+		err = r.checkFieldLine(len(data), n, done)
+		if err != nil {
+			return 0, err
+		}
+
 		if done {
-			r.state = requestStateParsingBody
+			err = r.endHeaders()
+			if err != nil {
+				return 0, err
+			}
 		}
 
 		return n, nil
-	case requestStateParsingBody:
-		contentLengthVal := r.Headers.Get("content-length")
-		if contentLengthVal == "" {
-			r.state = requestStateDone
+	case requestStateParsingBody, requestStateParsingChunkData:
+		// body data is copied straight into the caller's buffer by body.Read
+		return 0, nil
+	case requestStateParsingChunkSize:
+		chunkSize, n, err := parseChunkSize(string(data))
+		if err != nil {
+			log.Printf("error parsing chunk size: %v\n", err)
+			return 0, err
+		}
+		if n == 0 && len(data) > maxChunkSizeLineBytes {
+			return 0, fmt.Errorf("%w: chunk size line too long", ErrMalformedChunk)
+		}
+		if n == 0 {
+			return 0, nil
+		}
+
+		err = r.checkBody(chunkSize)
+		if err != nil {
+			return 0, err
+		}
+
+		if chunkSize == 0 {
+			r.fieldBytes = 0
+			r.fieldCount = 0
+			r.state = requestStateParsingTrailers
+		} else {
+			r.bytesRemaining = chunkSize
+			r.state = requestStateParsingChunkData
+		}
+
+		return n, nil
+	case requestStateParsingChunkDataEnd:
+		if len(data) < len(crlf) {
 			return 0, nil
 		}
+		if string(data[:len(crlf)]) != crlf {
+			return 0, fmt.Errorf("%w: missing crlf after chunk data", ErrMalformedChunk)
+		}
+
+		r.state = requestStateParsingChunkSize
 
-		contentLength, err := strconv.Atoi(contentLengthVal)
+		return len(crlf), nil
+	case requestStateParsingTrailers:
+		err := checkFolding(data)
 		if err != nil {
-			log.Printf("error converting string to int: %v\n", err)
 			return 0, err
 		}
-		if contentLength < 0 {
-			return 0, errors.New("invalid content length value")
+
+		n, done, err := r.Trailers.Parse(string(data))
+		if err != nil {
+			log.Printf("error parsing trailers: %v\n", err)
+			return 0, err
 		}
 
-		r.Body = append(r.Body, data...)
-		if len(r.Body) > contentLength {
-			return 0, errors.New("body length is greater than content length")
+		err = r.checkFieldLine(len(data), n, done)
+		if err != nil {
+			return 0, err
 		}
-		if len(r.Body) == contentLength {
+
+		if done {
 			r.state = requestStateDone
 		}
 
-		return len(data), nil
+		return n, nil
 	case requestStateDone:
 		return 0, errors.New("trying to read data in a done state")
 	default:
@@ -126,6 +367,93 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	}
 }
 
+// checkFolding rejects a field line starting with whitespace. It would be
+// the continuation of the previous line in the obsolete line folding
+// syntax, and parsers that do not unfold it see a different field.
+func checkFolding(data []byte) error {
+	if len(data) > 0 && (data[0] == ' ' || data[0] == '\t') {
+		return fmt.Errorf("%w: obsolete line folding", headers.ErrMalformedFieldLine)
+	}
+
+	return nil
+}
+
+// endHeaders checks the complete header section and prepares for the body.
+func (r *Request) endHeaders() error {
+	err := r.validateHost()
+	if err != nil {
+		log.Printf("error validating host: %v\n", err)
+		return err
+	}
+
+	err = r.startBody()
+	if err != nil {
+		log.Printf("error starting body: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// startBody determines how the body is delimited following RFC 9112 section
+// 6.3. Requests whose framing another server along the way could read
+// differently, which is what request smuggling relies on, are rejected
+// rather than interpreted.
+func (r *Request) startBody() error {
+	if r.Headers.Has("transfer-encoding") {
+		if r.RequestLine.HttpVersion == "1.0" {
+			return fmt.Errorf("%w: Transfer-Encoding in an HTTP/1.0 request", ErrAmbiguousFraming)
+		}
+		if r.Headers.Has("content-length") {
+			return fmt.Errorf("%w: both Transfer-Encoding and Content-Length", ErrAmbiguousFraming)
+		}
+
+		err := checkTransferEncoding(r.Headers)
+		if err != nil {
+			return err
+		}
+
+		r.state = requestStateParsingChunkSize
+		return nil
+	}
+
+	contentLength, err := parseContentLength(r.Headers)
+	if err != nil {
+		return err
+	}
+
+	err = r.checkBody(contentLength)
+	if err != nil {
+		return err
+	}
+
+	if contentLength == 0 {
+		r.state = requestStateDone
+		return nil
+	}
+
+	r.bytesRemaining = contentLength
+	r.state = requestStateParsingBody
+
+	return nil
+}
+
+func (r *Request) isParsingHead() bool {
+	return r.state == requestStateInitialized || r.state == requestStateParsingHeaders
+}
+
+func (r *Request) isParsingChunkedBody() bool {
+	switch r.state {
+	case requestStateParsingChunkSize,
+		requestStateParsingChunkData,
+		requestStateParsingChunkDataEnd,
+		requestStateParsingTrailers:
+		return true
+	default:
+		return false
+	}
+}
+
 func (r *Request) parse(data []byte) (int, error) {
 	totalBytesParsed := 0
 	for r.state != requestStateDone {
@@ -144,56 +472,49 @@ func (r *Request) parse(data []byte) (int, error) {
 	return totalBytesParsed, nil
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize)
-	readToIndex := 0
-
-	req := &Request{
-		Headers: headers.NewHeaders(),
-		state:   requestStateInitialized,
-	}
-
-	for req.state != requestStateDone {
-		if readToIndex >= len(buf) {
-			newBuf := make([]byte, len(buf)*2)
-			copy(newBuf, buf)
-			buf = newBuf
+func hasToken(fieldValue, token string) bool {
+	for _, v := range strings.Split(fieldValue, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
 		}
+	}
 
-		n, err := reader.Read(buf[readToIndex:])
-		if err == io.EOF {
-			req.state = requestStateDone
-			break
-		}
-		if err != nil {
-			log.Printf("error reading request: %v\n", err)
-			return nil, err
-		}
+	return false
+}
 
-		readToIndex += n
+// KeepAlive reports whether the client wants the connection kept open after
+// this request. HTTP/1.1 connections are persistent unless the client sends
+// "Connection: close"; HTTP/1.0 clients have to ask for "keep-alive".
+func (r *Request) KeepAlive() bool {
+	connection := strings.Join(r.Headers.Values("connection"), ",")
+	if r.RequestLine.HttpVersion == "1.0" {
+		return hasToken(connection, "keep-alive")
+	}
 
-		n, err = req.parse(buf[:readToIndex])
-		if err != nil {
-			log.Printf("error parsing request: %v\n", err)
-			return nil, err
-		}
+	return !hasToken(connection, "close")
+}
 
-		copy(buf, buf[n:readToIndex])
+// PathValue returns the value of the named path parameter captured when the
+// request was routed, or "" if there is none.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
 
-		readToIndex -= n
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = make(map[string]string)
 	}
+	r.pathValues[name] = value
+}
 
-	contentLengthVal := req.Headers.Get("content-length")
-	if req.state == requestStateDone && contentLengthVal != "" {
-		contentLength, err := strconv.Atoi(req.Headers.Get("content-length"))
-		if err != nil {
-			log.Printf("error parsing string to int: %v\n", err)
-			return nil, err
-		}
-		if len(req.Body) < contentLength {
-			return nil, errors.New("body length is less than content length")
-		}
-	}
+// ReadBody reads the remainder of the request body into memory.
+func (r *Request) ReadBody() ([]byte, error) {
+	return io.ReadAll(r.Body)
+}
 
-	return req, nil
+// RequestFromReader parses a single request from reader. It is a shorthand
+// for NewParser(reader).Next(); any bytes read past the end of the request
+// are discarded along with the parser.
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	return NewParser(reader).Next()
 }
@@ -1,7 +1,12 @@
 package request
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"httpfromtcp/internal/headers"
 	"io"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -105,20 +110,20 @@ func TestHeadersParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "curl/7.81.0", r.Headers.Get("user-agent"))
+	assert.Equal(t, "*/*", r.Headers.Get("accept"))
 
 	// Test: Empty Headers
 	reader = &chunkReader{
-		data: "GET / HTTP/1.1\r\n" +
+		data: "GET / HTTP/1.0\r\n" +
 			"\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, 0, len(r.Headers))
+	assert.Equal(t, 0, r.Headers.Len())
 
 	// Test: Malformed Header
 	reader = &chunkReader{
@@ -134,14 +139,15 @@ func TestHeadersParse(t *testing.T) {
 	reader = &chunkReader{
 		data: "GET / HTTP/1.1\r\n" +
 			"Host: localhost:42069\r\n" +
-			"Host: localhost:42069\r\n" +
+			"Accept: text/html\r\n" +
+			"Accept: text/html\r\n" +
 			"\r\n",
 		numBytesPerRead: 3,
 	}
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
+	assert.Equal(t, "text/html", r.Headers.Get("accept"))
 
 	// Test: Case insensitive Headers
 	reader = &chunkReader{
@@ -155,9 +161,9 @@ func TestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "curl/7.81.0", r.Headers.Get("user-agent"))
+	assert.Equal(t, "*/*", r.Headers.Get("accept"))
 
 	// Test: Missing end of Headers
 	reader = &chunkReader{
@@ -170,9 +176,9 @@ func TestHeadersParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "localhost:42069", r.Headers["host"])
-	assert.Equal(t, "curl/7.81.0", r.Headers["user-agent"])
-	assert.Equal(t, "*/*", r.Headers["accept"])
+	assert.Equal(t, "localhost:42069", r.Headers.Get("host"))
+	assert.Equal(t, "curl/7.81.0", r.Headers.Get("user-agent"))
+	assert.Equal(t, "*/*", r.Headers.Get("accept"))
 }
 
 func TestBodyParse(t *testing.T) {
@@ -188,7 +194,9 @@ func TestBodyParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
 
 	// Test: Body shorter than reported content length
 	reader = &chunkReader{
@@ -199,7 +207,9 @@ func TestBodyParse(t *testing.T) {
 			"partial content",
 		numBytesPerRead: 3,
 	}
-	_, err = RequestFromReader(reader)
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
 	require.Error(t, err)
 
 	// Test: Empty Body, 0 reported content length
@@ -214,7 +224,9 @@ func TestBodyParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body))
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "", string(body))
 
 	// Test: Empty Body, no reported content length
 	reader = &chunkReader{
@@ -227,7 +239,9 @@ func TestBodyParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body))
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "", string(body))
 
 	// Test: No Content-Length but Body Exists
 	reader = &chunkReader{
@@ -240,5 +254,742 @@ func TestBodyParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body))
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "", string(body))
+}
+
+func TestChunkedBodyParse(t *testing.T) {
+	// Test: Standard chunked Body
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\n" +
+			"hello\r\n" +
+			"7\r\n" +
+			" world!\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!", string(body))
+	assert.Equal(t, 0, r.Trailers.Len())
+
+	// Test: Chunked Body read one byte at a time
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"a\r\n" +
+			"0123456789\r\n" +
+			"1A\r\n" +
+			"abcdefghijklmnopqrstuvwxyz\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdefghijklmnopqrstuvwxyz", string(body))
+
+	// Test: Chunked Body with chunk extensions
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5;name=value\r\n" +
+			"hello\r\n" +
+			"0;last\r\n" +
+			"\r\n",
+		numBytesPerRead: 7,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: Chunked Body with trailers
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"5\r\n" +
+			"hello\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"X-Other: value\r\n" +
+			"\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, "abc123", r.Trailers.Get("x-checksum"))
+	assert.Equal(t, "value", r.Trailers.Get("x-other"))
+	assert.Equal(t, "", r.Headers.Get("x-checksum"))
+
+	// Test: Empty chunked Body
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 13,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "", string(body))
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"xyz\r\n" +
+			"hello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+
+	// Test: Chunk data longer than chunk size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\n" +
+			"hello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+
+	// Test: Missing terminating chunk
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\n" +
+			"hello\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+}
+
+func TestBodyStreaming(t *testing.T) {
+	// Test: Request is returned before the body has been sent
+	pr, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 11\r\n" +
+			"\r\n"))
+	}()
+	r, err := RequestFromReader(pr)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "/upload", r.RequestLine.RequestTarget)
+
+	go func() {
+		pw.Write([]byte("hello "))
+		pw.Write([]byte("world"))
+		pw.Close()
+	}()
+	body, err := io.ReadAll(r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+
+	// Test: Body reads stop at the content length
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 4,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	p := make([]byte, 64)
+	n, err := r.Body.Read(p)
+	require.NoError(t, err)
+	total := n
+	for err == nil {
+		n, err = r.Body.Read(p[total:])
+		total += n
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "hello", string(p[:total]))
+
+	// Test: Read after Close
+	require.NoError(t, r.Body.Close())
+	_, err = r.Body.Read(p)
+	require.Error(t, err)
+}
+
+func TestKeepAlive(t *testing.T) {
+	// Test: HTTP/1.1 defaults to keep-alive
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.KeepAlive())
+
+	// Test: HTTP/1.1 with Connection: close
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Connection: Close\r\n" +
+		"\r\n"))
+	require.NoError(t, err)
+	assert.False(t, r.KeepAlive())
+
+	// Test: Closed connection before any request
+	_, err = RequestFromReader(strings.NewReader(""))
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Closed connection in the middle of the request line
+	_, err = RequestFromReader(strings.NewReader("GET / HT"))
+	require.Error(t, err)
+	assert.NotEqual(t, io.EOF, err)
+}
+
+func TestParserPipelining(t *testing.T) {
+	pipelined := "GET /first HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"\r\n" +
+		"POST /second HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello" +
+		"POST /third HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"5\r\n" +
+		"world\r\n" +
+		"0\r\n" +
+		"\r\n" +
+		"GET /fourth HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"\r\n"
+
+	for _, numBytesPerRead := range []int{1, 3, 7, 64, len(pipelined)} {
+		// Test: Pipelined requests with bodies read in order
+		p := NewParser(&chunkReader{
+			data:            pipelined,
+			numBytesPerRead: numBytesPerRead,
+		})
+
+		r, err := p.Next()
+		require.NoError(t, err)
+		assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+		body, err := r.ReadBody()
+		require.NoError(t, err)
+		assert.Equal(t, "", string(body))
+
+		r, err = p.Next()
+		require.NoError(t, err)
+		assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+		body, err = r.ReadBody()
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+
+		r, err = p.Next()
+		require.NoError(t, err)
+		assert.Equal(t, "/third", r.RequestLine.RequestTarget)
+		body, err = r.ReadBody()
+		require.NoError(t, err)
+		assert.Equal(t, "world", string(body))
+
+		r, err = p.Next()
+		require.NoError(t, err)
+		assert.Equal(t, "/fourth", r.RequestLine.RequestTarget)
+
+		_, err = p.Next()
+		assert.Equal(t, io.EOF, err)
+	}
+
+	// Test: Unread bodies are skipped before the next request
+	p := NewParser(&chunkReader{
+		data:            pipelined,
+		numBytesPerRead: 5,
+	})
+	for _, target := range []string{"/first", "/second", "/third", "/fourth"} {
+		r, err := p.Next()
+		require.NoError(t, err)
+		assert.Equal(t, target, r.RequestLine.RequestTarget)
+		require.NoError(t, r.Body.Close())
+	}
+	_, err := p.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Incomplete pipelined request
+	p = NewParser(&chunkReader{
+		data: "GET /first HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n" +
+			"GET /sec",
+		numBytesPerRead: 3,
+	})
+	_, err = p.Next()
+	require.NoError(t, err)
+	_, err = p.Next()
+	require.Error(t, err)
+	assert.NotEqual(t, io.EOF, err)
+}
+
+func TestLimits(t *testing.T) {
+	newParser := func(data string, limits Limits) *Parser {
+		p := NewParser(&chunkReader{
+			data:            data,
+			numBytesPerRead: 3,
+		})
+		p.Limits = limits
+
+		return p
+	}
+
+	// Test: Request line within the limit
+	p := newParser("GET /abc HTTP/1.1\r\nHost: localhost\r\n\r\n", Limits{MaxRequestLineBytes: 17})
+	_, err := p.Next()
+	require.NoError(t, err)
+
+	// Test: Request line over the limit
+	p = newParser("GET /abcd HTTP/1.1\r\nHost: localhost\r\n\r\n", Limits{MaxRequestLineBytes: 17})
+	_, err = p.Next()
+	assert.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Request line over the limit without a line ending
+	p = newParser("GET /"+strings.Repeat("a", 100), Limits{MaxRequestLineBytes: 17})
+	_, err = p.Next()
+	assert.ErrorIs(t, err, ErrRequestLineTooLong)
+
+	// Test: Header section over the byte limit
+	p = newParser("GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: "+strings.Repeat("a", 100)+"\r\n\r\n", Limits{MaxHeaderBytes: 64})
+	_, err = p.Next()
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Header line that never ends
+	p = newParser("GET / HTTP/1.1\r\nX-Big: "+strings.Repeat("a", 100), Limits{MaxHeaderBytes: 64})
+	_, err = p.Next()
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Too many header lines
+	p = newParser("GET / HTTP/1.1\r\nHost: localhost\r\nA: 1\r\nB: 2\r\n\r\n", Limits{MaxHeaderCount: 2})
+	_, err = p.Next()
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Content-Length over the body limit is rejected before the body
+	p = newParser("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\nhello world", Limits{MaxBodyBytes: 10})
+	_, err = p.Next()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Chunked body over the body limit fails while reading it
+	p = newParser("POST / HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"\r\n"+
+		"6\r\nhello \r\n"+
+		"5\r\nworld\r\n"+
+		"0\r\n\r\n", Limits{MaxBodyBytes: 10})
+	r, err := p.Next()
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Trailers count against the header limits
+	p = newParser("POST / HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"\r\n"+
+		"0\r\n"+
+		"A: 1\r\nB: 2\r\nC: 3\r\n"+
+		"\r\n", Limits{MaxHeaderCount: 2})
+	r, err = p.Next()
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Chunk-size line that never ends
+	p = newParser("POST / HTTP/1.1\r\n"+
+		"Host: localhost\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"\r\n"+
+		"5;"+strings.Repeat("a", 5000), Limits{})
+	r, err = p.Next()
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+}
+
+func TestHTTP10(t *testing.T) {
+	// Test: HTTP/1.0 request without a Host header
+	r, err := RequestFromReader(strings.NewReader("GET /status HTTP/1.0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.RequestLine.HttpVersion)
+	assert.Equal(t, "/status", r.RequestLine.RequestTarget)
+	assert.False(t, r.KeepAlive())
+
+	// Test: Other versions are unsupported
+	for _, version := range []string{"0.9", "1.2", "2.0", "3.0"} {
+		_, err = RequestFromReader(strings.NewReader("GET / HTTP/" + version + "\r\n\r\n"))
+		assert.ErrorIs(t, err, ErrUnsupportedVersion, version)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		err     error
+	}{
+		{"Missing request target", "GET HTTP/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"Lowercase method", "get / HTTP/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"Invalid protocol", "GET / HTTPS/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"Unsupported version", "GET / HTTP/2.0\r\n\r\n", ErrUnsupportedVersion},
+		{"Incomplete request line", "GET / HT", ErrIncompleteRequest},
+		{"Header without colon", "GET / HTTP/1.1\r\nHost localhost\r\n\r\n", headers.ErrMalformedFieldLine},
+		{"Invalid header name", "GET / HTTP/1.1\r\nH@st: localhost\r\n\r\n", headers.ErrInvalidFieldName},
+		{"Invalid content length", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: ten\r\n\r\n", ErrInvalidContentLength},
+		{"Negative content length", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: -1\r\n\r\n", ErrInvalidContentLength},
+	}
+
+	for _, tt := range tests {
+		// Test: Parse failures wrap their error type
+		_, err := RequestFromReader(strings.NewReader(tt.request))
+		assert.ErrorIs(t, err, tt.err, tt.name)
+	}
+
+	// Test: Body shorter than its content length
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nhello"))
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, ErrLengthMismatch)
+
+	// Test: Invalid chunk size
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n"))
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, ErrMalformedChunk)
+}
+
+func TestRequestLineMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		err     error
+	}{
+		{"Method only", "GET\r\n\r\n", ErrMalformedRequestLine},
+		{"Missing version", "GET /\r\n\r\n", ErrMalformedRequestLine},
+		{"Protocol without version", "GET / HTTP\r\n\r\n", ErrMalformedRequestLine},
+		{"Protocol with empty version", "GET / HTTP/\r\n\r\n", ErrMalformedRequestLine},
+		{"Version without minor", "GET / HTTP/2\r\n\r\n", ErrMalformedRequestLine},
+		{"Multi-digit version", "GET / HTTP/1.10\r\n\r\n", ErrMalformedRequestLine},
+		{"Lowercase protocol", "GET / http/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"Double space", "GET  / HTTP/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"Leading space", " GET / HTTP/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"Trailing space", "GET / HTTP/1.1 \r\n\r\n", ErrMalformedRequestLine},
+		{"Tab separator", "GET\t/ HTTP/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"Method with separator", "GE(T / HTTP/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"Control character in target", "GET /\x7f HTTP/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"Non-ASCII target", "GET /caf\xc3\xa9 HTTP/1.1\r\n\r\n", ErrMalformedRequestLine},
+		{"Empty line", "\r\n\r\n", ErrMalformedRequestLine},
+		{"Unknown version", "GET / HTTP/3.0\r\n\r\n", ErrUnsupportedVersion},
+	}
+
+	for _, tt := range tests {
+		// Test: Malformed request lines are rejected without panicking
+		_, err := RequestFromReader(strings.NewReader(tt.request))
+		assert.ErrorIs(t, err, tt.err, tt.name)
+	}
+
+	// Test: Extension methods are accepted
+	r, err := RequestFromReader(strings.NewReader("PROPFIND /dav HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "PROPFIND", r.RequestLine.Method)
+}
+
+func FuzzRequestFromReader(f *testing.F) {
+	seeds := []string{
+		"GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+		"GET /coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		"/coffee HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		"get /coffee HTTP/1.1\r\n\r\n",
+		"GET /coffee HTTP/2\r\n\r\n",
+		"GET / HTTP/1.0\r\n\r\n",
+		"GET / HTTP/1.1\r\nHost localhost:42069\r\n\r\n",
+		"GET / HTTP/1.1\r\nSet-Person: lane-loves-go\r\nSet-Person: prime-loves-zig\r\n\r\n",
+		"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 13\r\n\r\nhello world!\n",
+		"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 20\r\n\r\npartial content",
+		"POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n",
+		"POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n",
+		"GET\r\n\r\n",
+		"GET / HTTP\r\n\r\n",
+	}
+	for _, seed := range seeds {
+		f.Add(seed, 3)
+	}
+
+	f.Fuzz(func(t *testing.T, data string, numBytesPerRead int) {
+		if numBytesPerRead < 1 {
+			numBytesPerRead = 1
+		}
+
+		r, err := RequestFromReader(&chunkReader{
+			data:            data,
+			numBytesPerRead: numBytesPerRead,
+		})
+		if err != nil {
+			return
+		}
+
+		assert.NotEmpty(t, r.RequestLine.Method)
+		assert.NotEmpty(t, r.RequestLine.RequestTarget)
+		assert.NotNil(t, r.URL)
+		assert.Contains(t, []string{"1.0", "1.1"}, r.RequestLine.HttpVersion)
+
+		r.ReadBody()
+	})
+}
+
+func TestRequestTarget(t *testing.T) {
+	parse := func(method, target string) (*Request, error) {
+		return RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n"))
+	}
+
+	// Test: Origin-form with a query
+	r, err := parse("GET", "/search?q=go&tag=a&tag=b")
+	require.NoError(t, err)
+	assert.Equal(t, "/search", r.URL.Path)
+	assert.Equal(t, "q=go&tag=a&tag=b", r.URL.RawQuery)
+	assert.Equal(t, "go", r.URL.Query().Get("q"))
+	assert.Equal(t, []string{"a", "b"}, r.URL.Query()["tag"])
+
+	// Test: Escaped path is decoded and kept as sent
+	r, err = parse("GET", "/files/a%20b/c%2Fd")
+	require.NoError(t, err)
+	assert.Equal(t, "/files/a b/c/d", r.URL.Path)
+	assert.Equal(t, "/files/a%20b/c%2Fd", r.URL.EscapedPath())
+
+	// Test: Absolute-form
+	r, err = parse("GET", "http://example.com:8080/index.html?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, "http", r.URL.Scheme)
+	assert.Equal(t, "example.com:8080", r.URL.Host)
+	assert.Equal(t, "/index.html", r.URL.Path)
+	assert.Equal(t, "x=1", r.URL.RawQuery)
+
+	// Test: Authority-form for CONNECT
+	r, err = parse("CONNECT", "example.com:443")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:443", r.URL.Host)
+	assert.Equal(t, "", r.URL.Path)
+
+	// Test: Asterisk-form for OPTIONS
+	r, err = parse("OPTIONS", "*")
+	require.NoError(t, err)
+	assert.Equal(t, "*", r.URL.Path)
+
+	// Test: Invalid targets
+	for _, tt := range []struct{ method, target string }{
+		{"GET", "/page#section"},
+		{"GET", "/bad%zzescape"},
+		{"GET", "relative/path"},
+		{"GET", "*"},
+		{"GET", "http:opaque"},
+		{"GET", "http:///no-host"},
+		{"CONNECT", "example.com"},
+		{"CONNECT", "/path"},
+	} {
+		_, err = parse(tt.method, tt.target)
+		assert.ErrorIs(t, err, ErrInvalidRequestTarget, tt.method+" "+tt.target)
+	}
+}
+
+func TestHostValidation(t *testing.T) {
+	parse := func(version string, hosts ...string) error {
+		raw := "GET / HTTP/" + version + "\r\n"
+		for _, host := range hosts {
+			raw += "Host: " + host + "\r\n"
+		}
+		_, err := RequestFromReader(strings.NewReader(raw + "\r\n"))
+		return err
+	}
+
+	// Test: Valid hosts
+	for _, host := range []string{"localhost", "localhost:42069", "example.com", "127.0.0.1:80", "[::1]:8080", "[2001:db8::1]", "xn--bcher-kva.example", ""} {
+		assert.NoError(t, parse("1.1", host), host)
+	}
+
+	// Test: Missing Host is only allowed for HTTP/1.0
+	assert.ErrorIs(t, parse("1.1"), ErrInvalidHost)
+	assert.NoError(t, parse("1.0"))
+
+	// Test: Duplicate Host
+	assert.ErrorIs(t, parse("1.1", "example.com", "example.com"), ErrInvalidHost)
+	assert.ErrorIs(t, parse("1.0", "example.com", "example.org"), ErrInvalidHost)
+
+	// Test: Malformed hosts
+	for _, host := range []string{"exa mple.com", "example.com:80a", "example.com/path", "user@example.com", "[::1", "[zz]:80", "[::1]80", "a:b:c"} {
+		assert.ErrorIs(t, parse("1.1", host), ErrInvalidHost, host)
+	}
+}
+
+func gzipped(t *testing.T, data string) string {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	_, err := zw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	return buf.String()
+}
+
+func deflated(t *testing.T, data string) string {
+	buf := &bytes.Buffer{}
+	zw := zlib.NewWriter(buf)
+	_, err := zw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	return buf.String()
+}
+
+func decodedRequest(t *testing.T, contentEncoding, body string, limits Limits) (*Request, error) {
+	p := NewParser(&chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Encoding: " + contentEncoding + "\r\n" +
+			"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+			"\r\n" + body,
+		numBytesPerRead: 7,
+	})
+	p.Limits = limits
+	p.DecodeContent = true
+
+	return p.Next()
+}
+
+func TestDecodeContent(t *testing.T) {
+	// Test: gzip body is decoded and its encoding fields removed
+	r, err := decodedRequest(t, "gzip", gzipped(t, "hello world"), DefaultLimits)
+	require.NoError(t, err)
+	assert.False(t, r.Headers.Has("content-encoding"))
+	assert.False(t, r.Headers.Has("content-length"))
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+
+	// Test: deflate body
+	r, err = decodedRequest(t, "deflate", deflated(t, "hello world"), DefaultLimits)
+	require.NoError(t, err)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+
+	// Test: Stacked codings are undone in reverse order
+	r, err = decodedRequest(t, "deflate, X-GZIP", gzipped(t, deflated(t, "hello world")), DefaultLimits)
+	require.NoError(t, err)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+
+	// Test: identity leaves the body as it is
+	r, err = decodedRequest(t, "identity", "hello world", DefaultLimits)
+	require.NoError(t, err)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(body))
+
+	// Test: Unknown coding
+	_, err = decodedRequest(t, "br", "hello world", DefaultLimits)
+	assert.ErrorIs(t, err, ErrUnsupportedContentEncoding)
+
+	// Test: Corrupt data
+	r, err = decodedRequest(t, "gzip", "hello world", DefaultLimits)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, ErrInvalidContentEncoding)
+
+	// Test: Decoded size counts against the body limit
+	bomb := gzipped(t, strings.Repeat("a", 1<<20))
+	limits := DefaultLimits
+	limits.MaxBodyBytes = 64 << 10
+	require.Less(t, len(bomb), int(limits.MaxBodyBytes))
+	r, err = decodedRequest(t, "gzip", bomb, limits)
+	require.NoError(t, err)
+	body, err = r.ReadBody()
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+	assert.LessOrEqual(t, int64(len(body)), limits.MaxBodyBytes)
+	_, err = r.Body.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+
+	// Test: Decoded size is bounded without a body limit
+	bomb = gzipped(t, strings.Repeat("a", DefaultMaxDecodedBodyBytes+1))
+	r, err = decodedRequest(t, "gzip", bomb, DefaultLimits)
+	require.NoError(t, err)
+	n, err := io.Copy(io.Discard, r.Body)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+	assert.LessOrEqual(t, n, int64(DefaultMaxDecodedBodyBytes))
+
+	// Test: Body at the default bound is decoded
+	r, err = decodedRequest(t, "gzip", gzipped(t, strings.Repeat("a", DefaultMaxDecodedBodyBytes)), DefaultLimits)
+	require.NoError(t, err)
+	n, err = io.Copy(io.Discard, r.Body)
+	require.NoError(t, err)
+	assert.Equal(t, int64(DefaultMaxDecodedBodyBytes), n)
+
+	// Test: Bodies are left encoded unless decoding is enabled
+	encoded := gzipped(t, "hello world")
+	r, err = RequestFromReader(strings.NewReader("POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Encoding: br\r\n" +
+		"Content-Length: " + strconv.Itoa(len(encoded)) + "\r\n" +
+		"\r\n" + encoded))
+	require.NoError(t, err)
+	assert.Equal(t, "br", r.Headers.Get("content-encoding"))
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, encoded, string(body))
 }
@@ -0,0 +1,234 @@
+package request
+
+import (
+	"httpfromtcp/internal/headers"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smuggled is a request that a second parser would see if the framing of the
+// request in front of it was read differently
+const smuggled = "GET /admin HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+func TestSmugglingPayloads(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+		err     error
+	}{
+		{
+			name: "CL.TE",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost\r\n" +
+				"Content-Length: 6\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"\r\n" +
+				"0\r\n\r\n" +
+				smuggled,
+			err: ErrAmbiguousFraming,
+		},
+		{
+			name: "TE.CL",
+			request: "POST / HTTP/1.1\r\n" +
+				"Host: localhost\r\n" +
+				"Transfer-Encoding: chunked\r\n" +
+				"Content-Length: 4\r\n" +
+				"\r\n" +
+				"5c\r\n" + smuggled + "\r\n0\r\n\r\n",
+			err: ErrAmbiguousFraming,
+		},
+		{
+			name:    "TE.TE unknown coding",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: xchunked\r\n\r\n",
+			err:     ErrInvalidTransferEncoding,
+		},
+		{
+			name:    "TE.TE second unknown coding",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: x\r\n\r\n",
+			err:     ErrInvalidTransferEncoding,
+		},
+		{
+			name:    "TE.TE coding after chunked",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked, identity\r\n\r\n",
+			err:     ErrInvalidTransferEncoding,
+		},
+		{
+			name:    "TE.TE chunked twice",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked, chunked\r\n\r\n",
+			err:     ErrInvalidTransferEncoding,
+		},
+		{
+			name:    "TE.TE compressed coding",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: gzip, chunked\r\n\r\n",
+			err:     ErrInvalidTransferEncoding,
+		},
+		{
+			name:    "TE.TE empty value",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: \r\n\r\n",
+			err:     ErrInvalidTransferEncoding,
+		},
+		{
+			name:    "TE.TE space before colon",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding : chunked\r\n\r\n",
+			err:     headers.ErrInvalidFieldName,
+		},
+		{
+			name:    "TE.TE folded header",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nX-Padding: a\r\n Transfer-Encoding: chunked\r\n\r\n",
+			err:     headers.ErrMalformedFieldLine,
+		},
+		{
+			name:    "TE.TE bare CR in value",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\rX: y\r\n\r\n",
+			err:     headers.ErrInvalidFieldValue,
+		},
+		{
+			name:    "TE in HTTP/1.0",
+			request: "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n",
+			err:     ErrAmbiguousFraming,
+		},
+		{
+			name:    "CL.CL differing lines",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!",
+			err:     ErrInvalidContentLength,
+		},
+		{
+			name:    "CL.CL differing list",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5, 6\r\n\r\nhello!",
+			err:     ErrInvalidContentLength,
+		},
+		{
+			name:    "CL.CL same value written differently",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nContent-Length: 05\r\n\r\nhello",
+			err:     ErrInvalidContentLength,
+		},
+		{
+			name:    "CL with plus sign",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: +5\r\n\r\nhello",
+			err:     ErrInvalidContentLength,
+		},
+		{
+			name:    "CL with minus sign",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: -5\r\n\r\nhello",
+			err:     ErrInvalidContentLength,
+		},
+		{
+			name:    "CL with inner whitespace",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5 0\r\n\r\nhello",
+			err:     ErrInvalidContentLength,
+		},
+		{
+			name:    "CL in hex",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 0x5\r\n\r\nhello",
+			err:     ErrInvalidContentLength,
+		},
+		{
+			name:    "CL empty",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: \r\n\r\n",
+			err:     ErrInvalidContentLength,
+		},
+		{
+			name:    "CL overflow",
+			request: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 99999999999999999999\r\n\r\n",
+			err:     ErrInvalidContentLength,
+		},
+	}
+
+	for _, tt := range tests {
+		// Test: Ambiguous framing is rejected before any body is read
+		_, err := RequestFromReader(strings.NewReader(tt.request))
+		assert.ErrorIs(t, err, tt.err, tt.name)
+	}
+
+	// Test: Header section ended by the connection is framed the same way
+	_, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Content-Length: 5\r\n" +
+		"Transfer-Encoding: chunked\r\n"))
+	assert.ErrorIs(t, err, ErrAmbiguousFraming)
+
+	r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Content-Length: 5\r\n"))
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, ErrLengthMismatch)
+
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Transfer-Encoding: chunked\r\n"))
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, ErrMalformedChunk)
+
+	// Test: Partial header line at the end of the connection
+	_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Content-Len"))
+	assert.ErrorIs(t, err, ErrIncompleteRequest)
+}
+
+func TestSmugglingChunkedPayloads(t *testing.T) {
+	tests := []struct {
+		name  string
+		chunk string
+	}{
+		{"Chunk size with sign", "+5\r\nhello\r\n0\r\n\r\n"},
+		{"Chunk size with prefix", "0x5\r\nhello\r\n0\r\n\r\n"},
+		{"Chunk size with leading space", " 5\r\nhello\r\n0\r\n\r\n"},
+		{"Bare LF in chunk extension", "5;a\nb\r\nhello\r\n0\r\n\r\n"},
+		{"Bare LF ending chunk size", "5\nhello\r\n0\r\n\r\n"},
+		{"Chunk data longer than its size", "3\r\nhello\r\n0\r\n\r\n"},
+		{"Folded trailer", "0\r\nX-Checksum: a\r\n b\r\n\r\n"},
+	}
+
+	for _, tt := range tests {
+		// Test: Malformed chunked bodies fail instead of being resynchronized
+		r, err := RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+			"Host: localhost\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			tt.chunk))
+		require.NoError(t, err, tt.name)
+		_, err = r.ReadBody()
+		assert.Error(t, err, tt.name)
+	}
+}
+
+func TestSmugglingAcceptedFraming(t *testing.T) {
+	// Test: Identical repeated Content-Length values
+	p := NewParser(strings.NewReader("POST / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Content-Length: 5\r\n" +
+		"Content-Length: 5, 5\r\n" +
+		"\r\n" +
+		"hello" +
+		smuggled))
+	r, err := p.Next()
+	require.NoError(t, err)
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+
+	// Test: The next request starts right after the declared body
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/admin", r.RequestLine.RequestTarget)
+	_, err = p.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// Test: Transfer codings are case-insensitive and may have whitespace
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Transfer-Encoding:\tChunked \r\n" +
+		"\r\n" +
+		"5\r\nhello\r\n0\r\n\r\n"))
+	require.NoError(t, err)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+}
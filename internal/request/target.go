@@ -0,0 +1,43 @@
+package request
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// parseRequestTarget parses target in one of the four forms of RFC 9112:
+// the authority-form of CONNECT requests, the asterisk-form of server-wide
+// OPTIONS requests, the origin-form of everything else and the absolute-form
+// sent to proxies. Fragments are never sent by clients, so they are rejected.
+func parseRequestTarget(method, target string) (*url.URL, error) {
+	if strings.Contains(target, "#") {
+		return nil, fmt.Errorf("%w: fragment in %q", ErrInvalidRequestTarget, target)
+	}
+
+	if method == "CONNECT" {
+		host, port, err := net.SplitHostPort(target)
+		if err != nil || host == "" || port == "" {
+			return nil, fmt.Errorf("%w: CONNECT needs host:port, got %q", ErrInvalidRequestTarget, target)
+		}
+		return &url.URL{Host: target}, nil
+	}
+
+	if target == "*" {
+		if method != "OPTIONS" {
+			return nil, fmt.Errorf("%w: * is only allowed for OPTIONS", ErrInvalidRequestTarget)
+		}
+		return &url.URL{Path: "*"}, nil
+	}
+
+	u, err := url.ParseRequestURI(target)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequestTarget, err)
+	}
+	if !strings.HasPrefix(target, "/") && (u.Host == "" || u.Opaque != "") {
+		return nil, fmt.Errorf("%w: %q is neither an absolute path nor an absolute URI", ErrInvalidRequestTarget, target)
+	}
+
+	return u, nil
+}
@@ -0,0 +1,58 @@
+package response
+
+import (
+	"httpfromtcp/internal/headers"
+	"io"
+	"log"
+)
+
+// BodyEncoder is consulted when the headers of a response are written. It
+// can return a writer that encodes the body on its way to dst, such as a
+// compressor, after adjusting h to describe the encoding, or nil to leave the
+// body as it is. The returned writer is closed at the end of the body.
+//
+// Encoded bodies are always sent chunked, as their length is only known once
+// they have been written. Content-Length and trailers declared by the handler
+// still apply to the body before encoding.
+type BodyEncoder func(statusCode StatusCode, h *headers.Headers, dst io.Writer) io.WriteCloser
+
+// chunkWriter writes each Write as a chunk of the response body.
+type chunkWriter struct {
+	w *Writer
+}
+
+func (cw chunkWriter) Write(p []byte) (int, error) {
+	_, err := cw.w.writeChunk(p)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// closeEncoder flushes what the encoder still holds and stops using it.
+func (w *Writer) closeEncoder() error {
+	if w.encoder == nil {
+		return nil
+	}
+
+	err := w.encoder.Close()
+	w.encoder = nil
+	if err != nil {
+		log.Printf("error closing body encoder: %v\n", err)
+		return err
+	}
+
+	return nil
+}
+
+// flushEncoder sends what the encoder holds if it supports flushing, as the
+// compressors in the standard library do.
+func (w *Writer) flushEncoder() error {
+	flusher, ok := w.encoder.(interface{ Flush() error })
+	if !ok {
+		return nil
+	}
+
+	return flusher.Flush()
+}
@@ -1,107 +1,661 @@
 package response
 
 import (
+	"errors"
 	"fmt"
 	"httpfromtcp/internal/headers"
 	"io"
 	"log"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 )
 
-type StatusCode int
+const crlf = "\r\n"
+
+type writerState int
 
 const (
-	StatusOK                  StatusCode = 200
-	StatusBadRequest          StatusCode = 400
-	StatusInternalServerError StatusCode = 500
+	writerStateStatusLine writerState = iota
+	writerStateHeaders
+	writerStateBody
+	writerStateTrailers
+	writerStateDone
 )
 
-const crlf = "\r\n"
-
+// Writer writes a response in the order HTTP requires: status line, headers,
+// body and, for chunked bodies, trailers. Calls made out of that order return
+// an error without writing anything. Writer also implements ResponseWriter,
+// which leaves the framing of the body to the writer; output held back by it
+// is sent before any of the lower-level body methods write.
 type Writer struct {
 	Res io.Writer
+	// SortHeaders makes WriteHeaders write fields sorted by name instead of
+	// in the order they were added.
+	SortHeaders bool
+	// HTTP10 adapts the response to an HTTP/1.0 client, which does not
+	// understand chunked framing. Chunked bodies are written as they are and
+	// delimited by closing the connection, and their trailers are dropped.
+	HTTP10 bool
+	// HeadRequest makes the writer answer a HEAD request: the status line and
+	// headers are written as they would be for GET, Content-Length included,
+	// but body bytes, chunk framing and trailers are discarded.
+	HeadRequest bool
+	// BodyEncoder, if set, is given the chance to encode the body when the
+	// headers are written.
+	BodyEncoder BodyEncoder
+
+	state          writerState
+	canKeepAlive   func() bool
+	keepAlive      bool
+	idleTimeout    time.Duration
+	statusCode     StatusCode
+	bytesWritten   int64
+	contentLength  int64
+	chunked        bool
+	wireChunked    bool
+	unframed       bool
+	encoder        io.WriteCloser
+	trailerAllowed bool
+	announced      []string
+	trailer        *headers.Headers
+
+	// state of the ResponseWriter methods until the headers are sent
+	header        *headers.Headers
+	pendingStatus StatusCode
+	buf           []byte
+}
+
+// NewWriter returns a Writer for res. canKeepAlive is consulted when the
+// headers are written to decide whether the connection stays open, and
+// idleTimeout is advertised to the client in the Keep-Alive header.
+func NewWriter(res io.Writer, canKeepAlive func() bool, idleTimeout time.Duration) *Writer {
+	return &Writer{
+		Res:          res,
+		canKeepAlive: canKeepAlive,
+		idleTimeout:  idleTimeout,
+	}
+}
+
+// KeepAlive reports whether the connection can be reused for another request
+// once the response has been written.
+func (w *Writer) KeepAlive() bool {
+	return w.keepAlive && w.state == writerStateDone
+}
+
+// Committed reports whether the status line has been written. Once it has,
+// the response can no longer be replaced, for example by an error page.
+func (w *Writer) Committed() bool {
+	return w.state != writerStateStatusLine
+}
+
+// StatusCode returns the status code the response is sent with: the one
+// written or set with WriteHeader, or 200 once Header or Write have been used
+// without setting one. It returns 0 while nothing has been written or set.
+func (w *Writer) StatusCode() StatusCode {
+	if w.pending() && w.pendingStatus == 0 {
+		return StatusOK
+	}
+	if w.pending() {
+		return w.pendingStatus
+	}
+
+	return w.statusCode
+}
+
+// BytesWritten returns the number of body bytes written so far, including
+// ones Write is holding back and not counting chunk framing.
+func (w *Writer) BytesWritten() int64 {
+	return w.bytesWritten + int64(len(w.buf))
 }
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	statusLine := "HTTP/1.1 "
-	switch statusCode {
-	case StatusOK:
-		statusLine += fmt.Sprintf("%v OK", statusCode)
-	case StatusBadRequest:
-		statusLine += fmt.Sprintf("%v Bad Request", statusCode)
-	case StatusInternalServerError:
-		statusLine += fmt.Sprintf("%v Internal Server Error", statusCode)
-	default:
-		statusLine += fmt.Sprintf("%v ", statusCode)
+	if w.state != writerStateStatusLine {
+		return errors.New("status line already written")
+	}
+	if !statusCode.Valid() {
+		return fmt.Errorf("invalid status code: %v", int(statusCode))
+	}
+	if statusCode.Informational() {
+		return fmt.Errorf("interim responses are not supported: %v", int(statusCode))
 	}
 
+	statusLine := fmt.Sprintf("HTTP/1.1 %v %v", int(statusCode), StatusText(statusCode))
 	_, err := w.Res.Write([]byte(statusLine + crlf))
 	if err != nil {
 		log.Printf("error writing status line: %v\n", err)
 		return err
 	}
 
+	w.statusCode = statusCode
+	w.state = writerStateHeaders
+
 	return nil
 }
 
-func GetDefaultHeaders(contentLen int) headers.Headers {
+func GetDefaultHeaders(contentLen int) *headers.Headers {
+	defHeaders := headers.NewHeaders()
+	defHeaders.Set("Content-Length", strconv.Itoa(contentLen))
+	defHeaders.Set("Content-Type", "text/plain")
+
+	return defHeaders
+}
+
+func getDefaultChunkedHeaders() *headers.Headers {
 	defHeaders := headers.NewHeaders()
-	defHeaders["Content-Length"] = strconv.Itoa(contentLen)
-	defHeaders["Connection"] = "closed"
-	defHeaders["Content-Type"] = "text/plain"
+	defHeaders.Set("Transfer-Encoding", "chunked")
+	defHeaders.Set("Content-Type", "text/plain")
 
 	return defHeaders
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
-	for k, v := range headers {
-		_, err := w.Res.Write([]byte(fmt.Sprintf("%v: %v%v", k, v, crlf)))
+// WriteError writes a plain-text error page holding the status code and its
+// text. The fields in h, which may be nil, are sent along with the default
+// headers.
+func (w *Writer) WriteError(statusCode StatusCode, h *headers.Headers) error {
+	body := fmt.Sprintf("%v %v\n", int(statusCode), StatusText(statusCode))
+	defHeaders := GetDefaultHeaders(len(body))
+	if h != nil {
+		for k, v := range h.All() {
+			defHeaders.Add(k, v)
+		}
+	}
+
+	err := w.WriteStatusLine(statusCode)
+	if err != nil {
+		return err
+	}
+	err = w.WriteHeaders(defHeaders)
+	if err != nil {
+		return err
+	}
+
+	return w.WriteBody([]byte(body))
+}
+
+func headerValue(h *headers.Headers, headerKey string) string {
+	return strings.Join(h.Values(headerKey), ", ")
+}
+
+func hasToken(fieldValue, token string) bool {
+	for _, v := range strings.Split(fieldValue, ",") {
+		if strings.EqualFold(strings.TrimSpace(v), token) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WriteHeaders writes the header section. Fields set through Header that h
+// does not have are sent as well, except for the framing fields, which only
+// h decides.
+func (w *Writer) WriteHeaders(h *headers.Headers) error {
+	switch w.state {
+	case writerStateStatusLine:
+		return errors.New("headers written before status line")
+	case writerStateHeaders:
+	default:
+		return errors.New("headers already written")
+	}
+
+	h = w.withHeader(h)
+	err := validateFields(h)
+	if err != nil {
+		return err
+	}
+
+	if !w.statusCode.AllowsBody() {
+		return w.writeBodylessHeaders(h)
+	}
+
+	w.chunked = hasToken(headerValue(h, "transfer-encoding"), "chunked")
+
+	announced, err := announcedTrailers(h)
+	if err != nil {
+		return err
+	}
+	if len(announced) > 0 && !w.chunked {
+		return errors.New("trailers can only be announced for a chunked body")
+	}
+	w.announced = announced
+	w.trailerAllowed = w.chunked && len(announced) > 0
+	w.contentLength = -1
+	if contentLengthVal := headerValue(h, "content-length"); contentLengthVal != "" && !w.chunked {
+		contentLength, err := strconv.ParseInt(contentLengthVal, 10, 64)
+		if err != nil || contentLength < 0 {
+			return fmt.Errorf("invalid content length: %q", contentLengthVal)
+		}
+		w.contentLength = contentLength
+	}
+
+	fields := h.Clone()
+	if w.chunked {
+		fields.Del("Content-Length")
+	}
+	if w.BodyEncoder != nil {
+		w.encoder = w.BodyEncoder(w.statusCode, fields, chunkWriter{w})
+	}
+	if w.encoder != nil {
+		fields.Del("Content-Length")
+		if !w.chunked {
+			fields.Add("Transfer-Encoding", "chunked")
+		}
+	}
+	w.wireChunked = w.chunked || w.encoder != nil
+	w.unframed = w.wireChunked && w.HTTP10
+
+	if w.unframed {
+		fields.Del("Transfer-Encoding")
+		fields.Del("Trailer")
+	}
+	isFramed := (w.wireChunked && !w.unframed) || (w.contentLength >= 0 && w.encoder == nil) ||
+		w.HeadRequest
+	w.setConnection(fields, isFramed)
+
+	w.state = writerStateBody
+	if w.contentLength == 0 && w.encoder == nil {
+		w.state = writerStateDone
+	}
+
+	err = w.writeFields(fields)
+	if err != nil {
+		return err
+	}
+
+	if w.contentLength == 0 && w.encoder != nil {
+		_, err = w.writeLastChunk()
+	}
+
+	return err
+}
+
+// withHeader returns h along with the fields set through Header that h does
+// not have, leaving out Content-Length, Transfer-Encoding and Trailer.
+func (w *Writer) withHeader(h *headers.Headers) *headers.Headers {
+	if w.header == nil {
+		return h
+	}
+
+	merged := h.Clone()
+	for k, v := range w.header.All() {
+		switch headers.CanonicalKey(k) {
+		case "Content-Length", "Transfer-Encoding", "Trailer":
+			continue
+		}
+		if !h.Has(k) {
+			merged.Add(k, v)
+		}
+	}
+
+	return merged
+}
+
+// writeBodylessHeaders writes the headers of a response whose status code
+// does not allow a body. Framing fields the status code forbids are dropped:
+// Transfer-Encoding and Trailer, and Content-Length except on a 304, where it
+// describes the representation.
+func (w *Writer) writeBodylessHeaders(h *headers.Headers) error {
+	fields := h.Clone()
+	fields.Del("Transfer-Encoding")
+	fields.Del("Trailer")
+	if w.statusCode != StatusNotModified {
+		fields.Del("Content-Length")
+	}
+
+	w.contentLength = 0
+	w.setConnection(fields, true)
+	w.state = writerStateDone
+
+	return w.writeFields(fields)
+}
+
+// setConnection decides whether the connection is kept alive after the
+// response, which takes a framed body, and sets the Connection field of
+// fields accordingly.
+func (w *Writer) setConnection(fields *headers.Headers, isFramed bool) {
+	w.keepAlive = w.canKeepAlive != nil && w.canKeepAlive() &&
+		isFramed && !hasToken(headerValue(fields, "connection"), "close")
+
+	fields.Del("Connection")
+	fields.Del("Keep-Alive")
+	fields.Set("Connection", "close")
+	if w.keepAlive {
+		fields.Set("Connection", "keep-alive")
+		if w.idleTimeout > 0 {
+			fields.Set("Keep-Alive", fmt.Sprintf("timeout=%v", int(w.idleTimeout.Seconds())))
+		}
+	}
+	if w.SortHeaders {
+		fields.Sort()
+	}
+}
+
+// announcedTrailers returns the canonical names listed in the Trailer field,
+// rejecting names that may not be sent as trailers.
+func announcedTrailers(h *headers.Headers) ([]string, error) {
+	announced := []string{}
+	for _, name := range strings.Split(headerValue(h, "trailer"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		err := headers.ValidateFieldName(name)
+		if err != nil {
+			return nil, err
+		}
+		if headers.IsForbiddenTrailer(name) {
+			return nil, fmt.Errorf("%v is not allowed as a trailer", headers.CanonicalKey(name))
+		}
+		announced = append(announced, headers.CanonicalKey(name))
+	}
+
+	return announced, nil
+}
+
+func validateFields(fields *headers.Headers) error {
+	for k, v := range fields.All() {
+		err := headers.ValidateField(k, v)
 		if err != nil {
-			log.Printf("error writing header: %v\n", err)
+			log.Printf("error validating header: %v\n", err)
 			return err
 		}
 	}
 
-	_, err := w.Res.Write([]byte(crlf))
+	return nil
+}
+
+// writeFields writes a header or trailer section, with names in canonical
+// form, in a single write.
+func (w *Writer) writeFields(fields *headers.Headers) error {
+	var section strings.Builder
+	for k, v := range fields.All() {
+		section.WriteString(fmt.Sprintf("%v: %v%v", headers.CanonicalKey(k), v, crlf))
+	}
+	section.WriteString(crlf)
+
+	_, err := w.Res.Write([]byte(section.String()))
 	if err != nil {
-		log.Printf("error writing crlf: %v\n", err)
+		log.Printf("error writing header: %v\n", err)
 		return err
 	}
 
 	return nil
 }
 
+// startBody writes whatever of the status line and headers is still missing
+// before the first body write: what was set through ResponseWriter if
+// anything was, 200 OK and defHeaders otherwise.
+func (w *Writer) startBody(defHeaders *headers.Headers) error {
+	if w.pending() {
+		return w.Flush()
+	}
+	if w.state == writerStateStatusLine {
+		err := w.WriteStatusLine(StatusOK)
+		if err != nil {
+			return err
+		}
+	}
+	if w.state == writerStateHeaders && !w.statusCode.AllowsBody() {
+		return w.WriteHeaders(headers.NewHeaders())
+	}
+	if w.state == writerStateHeaders {
+		return w.WriteHeaders(defHeaders)
+	}
+
+	return nil
+}
+
+// checkBodyAllowed fails for body bytes in a response whose status code does
+// not allow a body.
+func (w *Writer) checkBodyAllowed(p []byte) error {
+	statusCode := w.statusCode
+	if !w.Committed() {
+		statusCode = w.StatusCode()
+	}
+	if len(p) > 0 && statusCode != 0 && !statusCode.AllowsBody() {
+		return fmt.Errorf("%v responses cannot have a body", int(statusCode))
+	}
+
+	return nil
+}
+
 func (w *Writer) WriteBody(body []byte) error {
-	_, err := w.Res.Write(body)
+	err := w.checkBodyAllowed(body)
+	if err != nil {
+		return err
+	}
+	err = w.startBody(GetDefaultHeaders(len(body)))
+	if err != nil {
+		return err
+	}
+
+	if w.state != writerStateBody {
+		if len(body) == 0 && w.state == writerStateDone && !w.chunked {
+			return nil
+		}
+		return errors.New("body already written")
+	}
+	if w.chunked {
+		return errors.New("body of a chunked response has to be written with WriteChunkedBody")
+	}
+	if w.contentLength >= 0 && w.bytesWritten+int64(len(body)) > w.contentLength {
+		return fmt.Errorf("body is longer than the declared content length of %v", w.contentLength)
+	}
+
+	var n int
+	if w.encoder != nil {
+		n, err = w.encoder.Write(body)
+	} else {
+		n, err = w.writeBodyBytes(body)
+	}
+	w.bytesWritten += int64(n)
 	if err != nil {
 		log.Printf("error writing body: %v\n", err)
 		return err
 	}
 
+	if w.bytesWritten == w.contentLength {
+		if w.wireChunked {
+			_, err = w.writeLastChunk()
+			return err
+		}
+		w.state = writerStateDone
+	}
+
 	return nil
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	err := w.checkBodyAllowed(p)
+	if err != nil {
+		return 0, err
+	}
+	err = w.startBody(getDefaultChunkedHeaders())
+	if err != nil {
+		return 0, err
+	}
+
+	if !w.chunked {
+		return 0, errors.New("response is not chunked")
+	}
+	if w.state != writerStateBody {
+		return 0, errors.New("chunked body already done")
+	}
+	if w.encoder != nil {
+		n, err := w.encoder.Write(p)
+		w.bytesWritten += int64(n)
+		return n, err
+	}
+
+	n, err := w.writeChunk(p)
+	if err != nil {
+		return 0, err
+	}
+
+	w.bytesWritten += int64(len(p))
+
+	return n, err
+}
+
+// writeChunk frames p as a chunk and returns the number of bytes written
+// including the framing.
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	if len(p) == 0 {
+		// an empty chunk would end the body
+		return 0, nil
+	}
+
 	chunkedBody := fmt.Sprintf("%X%v%s%v", len(p), crlf, p, crlf)
-	n, err := w.Res.Write([]byte(chunkedBody))
+	if w.unframed {
+		chunkedBody = string(p)
+	}
+	n, err := w.writeBodyBytes([]byte(chunkedBody))
 	if err != nil {
 		log.Printf("error writing chunked body: %v", err)
 		return 0, err
 	}
 
-	return n, err
+	return n, nil
 }
+
+// WriteChunkedBodyDone writes the last chunk. If the headers announced
+// trailers with a Trailer field, the response is completed by WriteTrailers,
+// otherwise it is complete now.
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
-	chunkedBody := fmt.Sprintf("%X%v%v", 0, crlf, crlf)
-	n, err := w.Res.Write([]byte(chunkedBody))
+	err := w.startBody(getDefaultChunkedHeaders())
+	if err != nil {
+		return 0, err
+	}
+
+	if !w.chunked {
+		return 0, errors.New("response is not chunked")
+	}
+	if w.state != writerStateBody {
+		return 0, errors.New("chunked body already done")
+	}
+
+	return w.writeLastChunk()
+}
+
+// writeLastChunk ends a chunked body, flushing the encoder first if there is
+// one.
+func (w *Writer) writeLastChunk() (int, error) {
+	err := w.closeEncoder()
+	if err != nil {
+		return 0, err
+	}
+
+	chunkedBody := fmt.Sprintf("%X%v", 0, crlf)
+	if !w.trailerAllowed {
+		chunkedBody += crlf
+	}
+	if w.unframed {
+		chunkedBody = ""
+	}
+
+	n, err := w.writeBodyBytes([]byte(chunkedBody))
 	if err != nil {
 		log.Printf("error writing end of chunked body: %v", err)
 		return 0, err
 	}
 
+	w.state = writerStateDone
+	if w.trailerAllowed {
+		w.state = writerStateTrailers
+	}
+
 	return n, err
 }
 
-func (w *Writer) WriteTrailers(headers headers.Headers) error {
-	return w.WriteHeaders(headers)
+func (w *Writer) WriteTrailers(trailers *headers.Headers) error {
+	switch {
+	case !w.chunked:
+		return errors.New("trailers can only be sent with a chunked body")
+	case !w.trailerAllowed:
+		return errors.New("trailers were not announced in a Trailer header")
+	case w.state == writerStateBody:
+		return errors.New("trailers written before the end of the chunked body")
+	case w.state != writerStateTrailers:
+		return errors.New("trailers already written")
+	}
+
+	err := validateFields(trailers)
+	if err != nil {
+		return err
+	}
+	for k := range trailers.All() {
+		if !slices.Contains(w.announced, headers.CanonicalKey(k)) {
+			return fmt.Errorf("trailer %v was not announced in the Trailer header", headers.CanonicalKey(k))
+		}
+	}
+
+	w.state = writerStateDone
+	if w.unframed || w.HeadRequest {
+		return nil
+	}
+
+	return w.writeFields(trailers)
+}
+
+// writeBodyBytes writes what follows the header section, unless the
+// response is to a HEAD request and has no content.
+func (w *Writer) writeBodyBytes(p []byte) (int, error) {
+	if w.HeadRequest {
+		return len(p), nil
+	}
+
+	return w.Res.Write(p)
+}
+
+// Finish completes a response the handler left unfinished where that can be
+// done without corrupting it: output held back by ResponseWriter is sent,
+// with a Content-Length if nothing was sent yet, an untouched response is
+// sent as 200 OK, missing headers are written for an empty body, a chunked
+// body is ended and the trailers set through Trailer are written. A response
+// that cannot be completed, such as a body shorter than its Content-Length,
+// keeps the connection from being reused.
+func (w *Writer) Finish() error {
+	if w.pending() {
+		err := w.commit(true)
+		if err != nil {
+			return err
+		}
+	}
+
+	if w.state == writerStateStatusLine {
+		err := w.WriteStatusLine(StatusOK)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch w.state {
+	case writerStateHeaders:
+		return w.startBody(GetDefaultHeaders(0))
+	case writerStateBody:
+		if w.contentLength >= 0 && w.HeadRequest {
+			// the Content-Length describes the body GET would have sent
+			w.state = writerStateDone
+			return nil
+		}
+		if w.contentLength >= 0 {
+			return errors.New("body is shorter than the declared content length")
+		}
+		if !w.wireChunked {
+			// the body is delimited by closing the connection
+			return nil
+		}
+		_, err := w.writeLastChunk()
+		if err != nil || w.state != writerStateTrailers {
+			return err
+		}
+		return w.WriteTrailers(w.trailerValues())
+	case writerStateTrailers:
+		return w.WriteTrailers(w.trailerValues())
+	}
+
+	return nil
 }
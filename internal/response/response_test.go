@@ -0,0 +1,776 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newHeaders(keyVals ...string) *headers.Headers {
+	h := headers.NewHeaders()
+	for i := 0; i < len(keyVals); i += 2 {
+		h.Add(keyVals[i], keyVals[i+1])
+	}
+
+	return h
+}
+
+func TestWriteStatusLine(t *testing.T) {
+	// Test: Registered status codes
+	for statusCode, statusLine := range map[StatusCode]string{
+		StatusOK:                      "HTTP/1.1 200 OK\r\n",
+		StatusCreated:                 "HTTP/1.1 201 Created\r\n",
+		StatusNoContent:               "HTTP/1.1 204 No Content\r\n",
+		StatusPermanentRedirect:       "HTTP/1.1 308 Permanent Redirect\r\n",
+		StatusNotFound:                "HTTP/1.1 404 Not Found\r\n",
+		StatusTooManyRequests:         "HTTP/1.1 429 Too Many Requests\r\n",
+		StatusGatewayTimeout:          "HTTP/1.1 504 Gateway Timeout\r\n",
+		StatusHTTPVersionNotSupported: "HTTP/1.1 505 HTTP Version Not Supported\r\n",
+	} {
+		buf := &bytes.Buffer{}
+		w := &Writer{Res: buf}
+		require.NoError(t, w.WriteStatusLine(statusCode))
+		assert.Equal(t, statusLine, buf.String())
+		assert.Equal(t, statusCode, w.StatusCode())
+	}
+
+	// Test: Unregistered status code keeps an empty reason phrase
+	buf := &bytes.Buffer{}
+	w := &Writer{Res: buf}
+	require.NoError(t, w.WriteStatusLine(StatusCode(599)))
+	assert.Equal(t, "HTTP/1.1 599 \r\n", buf.String())
+
+	// Test: Status codes outside 100-999 are rejected
+	for _, statusCode := range []StatusCode{0, 99, 1000, -200} {
+		buf := &bytes.Buffer{}
+		w := &Writer{Res: buf}
+		require.Error(t, w.WriteStatusLine(statusCode))
+		assert.Equal(t, "", buf.String())
+		assert.Equal(t, StatusCode(0), w.StatusCode())
+	}
+}
+
+func TestStatusText(t *testing.T) {
+	assert.Equal(t, "Continue", StatusText(StatusContinue))
+	assert.Equal(t, "Content Too Large", StatusText(StatusContentTooLarge))
+	assert.Equal(t, "Request Header Fields Too Large", StatusText(StatusRequestHeaderFieldsTooLarge))
+	assert.Equal(t, "Service Unavailable", StatusText(StatusServiceUnavailable))
+	assert.Equal(t, "", StatusText(StatusCode(299)))
+}
+
+func TestWriterOrder(t *testing.T) {
+	// Test: Status line, headers and body in order
+	buf := &bytes.Buffer{}
+	w := &Writer{Res: buf}
+	assert.False(t, w.Committed())
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	assert.True(t, w.Committed())
+	require.NoError(t, w.WriteHeaders(newHeaders("Content-Length", "5")))
+	require.NoError(t, w.WriteBody([]byte("hello")))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhello", buf.String())
+
+	// Test: Status line written twice
+	require.Error(t, w.WriteStatusLine(StatusOK))
+
+	// Test: Headers written twice
+	require.Error(t, w.WriteHeaders(newHeaders("Content-Length", "5")))
+
+	// Test: Body longer than the declared content length
+	require.Error(t, w.WriteBody([]byte("!")))
+
+	// Test: Headers before status line
+	w = &Writer{Res: &bytes.Buffer{}}
+	require.Error(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	assert.False(t, w.Committed())
+
+	// Test: Body before status line writes default status and headers
+	buf = &bytes.Buffer{}
+	w = &Writer{Res: buf}
+	require.NoError(t, w.WriteBody([]byte("hello")))
+	assert.Equal(t, StatusOK, w.StatusCode())
+	assert.Contains(t, buf.String(), "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, buf.String(), "Content-Length: 5\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhello"))
+
+	// Test: Body after status line writes default headers
+	buf = &bytes.Buffer{}
+	w = &Writer{Res: buf}
+	require.NoError(t, w.WriteStatusLine(StatusCreated))
+	require.NoError(t, w.WriteBody([]byte("hi")))
+	assert.Contains(t, buf.String(), "HTTP/1.1 201 Created\r\n")
+	assert.Contains(t, buf.String(), "Content-Length: 2\r\n")
+
+	// Test: Chunked body before status line writes chunked headers
+	buf = &bytes.Buffer{}
+	w = &Writer{Res: buf}
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "Transfer-Encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n5\r\nhello\r\n0\r\n\r\n"))
+	_, err = w.WriteChunkedBody([]byte("more"))
+	require.Error(t, err)
+
+	// Test: Chunked body on a Content-Length response
+	w = &Writer{Res: &bytes.Buffer{}}
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.Error(t, err)
+
+	// Test: Plain body on a chunked response
+	w = &Writer{Res: &bytes.Buffer{}}
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(newHeaders("Transfer-Encoding", "chunked")))
+	require.Error(t, w.WriteBody([]byte("hello")))
+
+	// Test: Content-Length is not sent along with chunked encoding
+	buf = &bytes.Buffer{}
+	w = &Writer{Res: buf}
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(newHeaders("Content-Length", "5", "Transfer-Encoding", "chunked")))
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.NotContains(t, buf.String(), "Content-Length")
+
+	// Test: Same through Header
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, nil, 0)
+	w.Header().Set("Content-Length", "5")
+	w.Header().Set("Transfer-Encoding", "chunked")
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	res, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "", res.Header.Get("Content-Length"))
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestWriterTrailers(t *testing.T) {
+	// Test: Trailers after an announced chunked body
+	buf := &bytes.Buffer{}
+	w := &Writer{Res: buf}
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(newHeaders("Transfer-Encoding", "chunked", "Trailer", "X-Checksum")))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	require.Error(t, w.WriteTrailers(newHeaders("X-Checksum", "abc")))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(newHeaders("X-Checksum", "abc")))
+	assert.True(t, strings.HasSuffix(buf.String(), "5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n"))
+	require.Error(t, w.WriteTrailers(newHeaders("X-Checksum", "abc")))
+
+	// Test: Trailers without chunked encoding
+	w = &Writer{Res: &bytes.Buffer{}}
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	require.Error(t, w.WriteTrailers(newHeaders("X-Checksum", "abc")))
+
+	// Test: Trailers that were not announced
+	w = &Writer{Res: &bytes.Buffer{}}
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(newHeaders("Transfer-Encoding", "chunked")))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.Error(t, w.WriteTrailers(newHeaders("X-Checksum", "abc")))
+}
+
+func TestWriterFinish(t *testing.T) {
+	keepAlive := func() bool { return true }
+
+	// Test: Unfinished chunked body is ended
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, keepAlive, 0)
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.False(t, w.KeepAlive())
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "5\r\nhello\r\n0\r\n\r\n"))
+	assert.True(t, w.KeepAlive())
+
+	// Test: Missing headers are written for an empty body
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	require.NoError(t, w.WriteStatusLine(StatusAccepted))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "Content-Length: 0\r\n")
+	assert.True(t, w.KeepAlive())
+
+	// Test: Body shorter than its content length cannot be finished
+	w = NewWriter(&bytes.Buffer{}, keepAlive, 0)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(10)))
+	require.NoError(t, w.WriteBody([]byte("short")))
+	require.Error(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+
+	// Test: Nothing written sends an empty 200 OK
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.Committed())
+	res, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, int64(0), res.ContentLength)
+	assert.True(t, w.KeepAlive())
+}
+
+func TestWriteError(t *testing.T) {
+	// Test: Error page with extra fields
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, nil, 0)
+	require.NoError(t, w.WriteError(StatusMethodNotAllowed, newHeaders("Allow", "GET, HEAD")))
+	assert.Equal(t, "HTTP/1.1 405 Method Not Allowed\r\n"+
+		"Content-Length: 23\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Allow: GET, HEAD\r\n"+
+		"Connection: close\r\n"+
+		"\r\n"+
+		"405 Method Not Allowed\n", buf.String())
+
+	// Test: Response already started
+	require.Error(t, w.WriteError(StatusNotFound, nil))
+
+	// Test: Fields set through Header are kept, but not their framing
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, nil, 0)
+	w.Header().Set("X-Request-Id", "abc")
+	w.Header().Add("Set-Cookie", "a=1")
+	w.Header().Add("Set-Cookie", "b=2")
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteError(StatusNotFound, nil))
+	res, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", res.Header.Get("X-Request-Id"))
+	assert.Equal(t, []string{"a=1", "b=2"}, res.Header.Values("Set-Cookie"))
+	assert.Equal(t, "text/plain", res.Header.Get("Content-Type"))
+	assert.Nil(t, res.TransferEncoding)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "404 Not Found\n", string(body))
+}
+
+func TestWriterHTTP10(t *testing.T) {
+	keepAlive := func() bool { return true }
+
+	// Test: Chunked body is written without framing and closes the connection
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, keepAlive, 0)
+	w.HTTP10 = true
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(newHeaders(
+		"Transfer-Encoding", "chunked",
+		"Trailer", "X-Checksum",
+	)))
+	_, err := w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(newHeaders("X-Checksum", "abc")))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Connection: close\r\n"+
+		"\r\n"+
+		"hello world", buf.String())
+	assert.False(t, w.KeepAlive())
+
+	// Test: Content-Length bodies keep the connection if the client asked to
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	w.HTTP10 = true
+	require.NoError(t, w.WriteBody([]byte("hello")))
+	assert.Contains(t, buf.String(), "Connection: keep-alive\r\n")
+	assert.True(t, w.KeepAlive())
+}
+
+func TestWriteHeadersRepeatedFields(t *testing.T) {
+	// Test: Each value of a repeated field is written on its own line
+	buf := &bytes.Buffer{}
+	w := &Writer{Res: buf}
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(newHeaders(
+		"Set-Cookie", "a=1; Path=/",
+		"Content-Length", "0",
+		"Set-Cookie", "b=2; Expires=Wed, 21 Oct 2015 07:28:00 GMT",
+	)))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Set-Cookie: a=1; Path=/\r\n"+
+		"Content-Length: 0\r\n"+
+		"Set-Cookie: b=2; Expires=Wed, 21 Oct 2015 07:28:00 GMT\r\n"+
+		"Connection: close\r\n"+
+		"\r\n", buf.String())
+}
+
+func TestWriteHeadersDeterministic(t *testing.T) {
+	h := newHeaders(
+		"x-request-id", "abc",
+		"content-type", "text/plain",
+		"Content-Length", "0",
+		"connection", "keep-alive",
+	)
+
+	// Test: Fields are written in insertion order with canonical names
+	for range 10 {
+		buf := &bytes.Buffer{}
+		w := &Writer{Res: buf}
+		require.NoError(t, w.WriteStatusLine(StatusOK))
+		require.NoError(t, w.WriteHeaders(h))
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+			"X-Request-Id: abc\r\n"+
+			"Content-Type: text/plain\r\n"+
+			"Content-Length: 0\r\n"+
+			"Connection: close\r\n"+
+			"\r\n", buf.String())
+	}
+
+	// Test: Fields are sorted by name on request
+	buf := &bytes.Buffer{}
+	w := &Writer{Res: buf, SortHeaders: true}
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Connection: close\r\n"+
+		"Content-Length: 0\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"X-Request-Id: abc\r\n"+
+		"\r\n", buf.String())
+
+	// Test: Default headers are found by lower-case names
+	assert.Equal(t, "12", GetDefaultHeaders(12).Get("content-length"))
+}
+
+func TestWriteHeadersInjection(t *testing.T) {
+	// Test: Values that would split the response are rejected before writing
+	buf := &bytes.Buffer{}
+	w := &Writer{Res: buf}
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	statusLine := buf.String()
+	err := w.WriteHeaders(newHeaders(
+		"Content-Length", "0",
+		"Location", "/next\r\nSet-Cookie: session=stolen",
+	))
+	assert.ErrorIs(t, err, headers.ErrInvalidFieldValue)
+	assert.Equal(t, statusLine, buf.String())
+
+	// Test: Writer can still write valid headers afterwards
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+
+	// Test: Invalid names are rejected
+	w = &Writer{Res: &bytes.Buffer{}}
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	err = w.WriteHeaders(newHeaders("X-Bad Name", "value"))
+	assert.ErrorIs(t, err, headers.ErrInvalidFieldName)
+
+	// Test: Trailer values are validated too
+	w = &Writer{Res: &bytes.Buffer{}}
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(newHeaders("Transfer-Encoding", "chunked", "Trailer", "X-Checksum")))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	err = w.WriteTrailers(newHeaders("X-Checksum", "abc\x00"))
+	assert.ErrorIs(t, err, headers.ErrInvalidFieldValue)
+}
+
+func TestResponseWriterFraming(t *testing.T) {
+	keepAlive := func() bool { return true }
+
+	// Test: Small body is buffered and sent with a Content-Length
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, keepAlive, 0)
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(StatusCreated)
+	n, err := w.Write([]byte("hello "))
+	require.NoError(t, err)
+	assert.Equal(t, 6, n)
+	_, err = w.Write([]byte("world"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, buf.Len())
+	assert.False(t, w.Committed())
+	assert.Equal(t, StatusCreated, w.StatusCode())
+	assert.Equal(t, int64(11), w.BytesWritten())
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 201 Created\r\n"+
+		"Content-Type: text/html\r\n"+
+		"Content-Length: 11\r\n"+
+		"Connection: keep-alive\r\n"+
+		"\r\n"+
+		"hello world", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Large body switches to chunked encoding
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	large := strings.Repeat("a", writeBufferSize+1)
+	_, err = w.Write([]byte(large))
+	require.NoError(t, err)
+	assert.True(t, w.Committed())
+	_, err = w.Write([]byte("tail"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	res, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "text/plain", res.Header.Get("Content-Type"))
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, large+"tail", string(body))
+	assert.True(t, w.KeepAlive())
+
+	// Test: Flush streams the buffered body chunked
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	_, err = w.Write([]byte("event 1\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.True(t, strings.HasSuffix(buf.String(), "8\r\nevent 1\n\r\n"))
+	_, err = w.Write([]byte("event 2\n"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(buf.String(), "8\r\nevent 2\n\r\n"))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "0\r\n\r\n"))
+
+	// Test: Explicit Content-Length is streamed without buffering
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	w.Header().Set("Content-Length", "10")
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhello"))
+	_, err = w.Write([]byte("world!"))
+	require.Error(t, err)
+
+	// Test: Status code alone sends an empty body
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	w.WriteHeader(StatusAccepted)
+	w.WriteHeader(StatusOK)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 202 Accepted\r\n"))
+	assert.Contains(t, buf.String(), "Content-Length: 0\r\n")
+
+	// Test: Lower-level body methods send buffered output first
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	w.Header().Set("Trailer", "X-Checksum")
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(newHeaders("X-Checksum", "abc")))
+	assert.True(t, strings.HasSuffix(buf.String(), "5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n"))
+}
+
+func TestWriterStatusCode(t *testing.T) {
+	// Test: 0 until something is written or set
+	w := NewWriter(&bytes.Buffer{}, nil, 0)
+	assert.Equal(t, StatusCode(0), w.StatusCode())
+
+	// Test: Pending output without a status code is sent as 200
+	w.Header().Set("X-Test", "1")
+	assert.Equal(t, StatusOK, w.StatusCode())
+
+	// Test: Status code set with WriteHeader
+	w = NewWriter(&bytes.Buffer{}, nil, 0)
+	w.WriteHeader(StatusNotFound)
+	assert.Equal(t, StatusNotFound, w.StatusCode())
+	_, err := w.Write([]byte("missing"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, StatusNotFound, w.StatusCode())
+}
+
+func TestBodylessStatus(t *testing.T) {
+	keepAlive := func() bool { return true }
+
+	for _, statusCode := range []StatusCode{StatusNoContent, StatusNotModified} {
+		// Test: No framing or default Content-Type
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf, keepAlive, 0)
+		w.WriteHeader(statusCode)
+		require.NoError(t, w.Finish())
+		assert.Equal(t, fmt.Sprintf("HTTP/1.1 %v %v\r\n", int(statusCode), StatusText(statusCode))+
+			"Connection: keep-alive\r\n"+
+			"\r\n", buf.String())
+		assert.True(t, w.KeepAlive())
+
+		// Test: Body bytes are refused
+		w = NewWriter(&bytes.Buffer{}, keepAlive, 0)
+		w.WriteHeader(statusCode)
+		_, err := w.Write([]byte("hello"))
+		require.Error(t, err)
+		_, err = w.Write(nil)
+		require.NoError(t, err)
+
+		w = NewWriter(&bytes.Buffer{}, keepAlive, 0)
+		require.NoError(t, w.WriteStatusLine(statusCode))
+		require.Error(t, w.WriteBody([]byte("hello")))
+		_, err = w.WriteChunkedBody([]byte("hello"))
+		require.Error(t, err)
+		require.NoError(t, w.WriteBody(nil))
+		assert.True(t, w.KeepAlive())
+	}
+
+	// Test: Informational status codes are refused
+	for _, statusCode := range []StatusCode{StatusContinue, StatusEarlyHints, 199} {
+		buf := &bytes.Buffer{}
+		w := NewWriter(buf, keepAlive, 0)
+		require.Error(t, w.WriteStatusLine(statusCode))
+		assert.False(t, w.Committed())
+		assert.Equal(t, 0, buf.Len())
+
+		w.WriteHeader(statusCode)
+		_, err := w.Write([]byte("hello"))
+		require.NoError(t, err)
+		require.NoError(t, w.Finish())
+		assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\n"))
+	}
+
+	// Test: Framing fields set by the handler are dropped
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, keepAlive, 0)
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(newHeaders("Content-Length", "0", "Transfer-Encoding", "chunked", "X-Test", "1")))
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n"+
+		"X-Test: 1\r\n"+
+		"Connection: keep-alive\r\n"+
+		"\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+
+	// Test: 304 keeps the Content-Length of the representation
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	w.Header().Set("Content-Length", "1000")
+	w.Header().Set("ETag", `"abc"`)
+	w.WriteHeader(StatusNotModified)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 304 Not Modified\r\n"+
+		"Content-Length: 1000\r\n"+
+		"Etag: \"abc\"\r\n"+
+		"Connection: keep-alive\r\n"+
+		"\r\n", buf.String())
+	assert.True(t, w.KeepAlive())
+}
+
+func TestTrailerAPI(t *testing.T) {
+	keepAlive := func() bool { return true }
+
+	// Test: Declared trailers are set while streaming and sent at the end
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, keepAlive, 0)
+	require.NoError(t, w.DeclareTrailer("x-checksum"))
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	w.Trailer().Set("X-Checksum", "abc")
+	w.Trailer().Set("X-Undeclared", "dropped")
+	require.NoError(t, w.Finish())
+	res, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, http.Header{"X-Checksum": {"abc"}}, res.Trailer)
+	assert.True(t, w.KeepAlive())
+
+	// Test: Forbidden and invalid trailer names cannot be declared
+	w = NewWriter(&bytes.Buffer{}, keepAlive, 0)
+	for _, name := range []string{"Content-Length", "transfer-encoding", "Host", "Set-Cookie", "Trailer", "X Bad"} {
+		require.Error(t, w.DeclareTrailer(name), name)
+	}
+
+	// Test: Trailers cannot be declared once the headers are sent
+	require.NoError(t, w.Flush())
+	require.Error(t, w.DeclareTrailer("X-Checksum"))
+
+	// Test: Forbidden trailer announced in the headers
+	w = NewWriter(&bytes.Buffer{}, keepAlive, 0)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.Error(t, w.WriteHeaders(newHeaders("Transfer-Encoding", "chunked", "Trailer", "X-Checksum, Content-Type")))
+
+	// Test: Trailers announced for a body that is not chunked
+	w = NewWriter(&bytes.Buffer{}, keepAlive, 0)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.Error(t, w.WriteHeaders(newHeaders("Content-Length", "5", "Trailer", "X-Checksum")))
+
+	// Test: Only announced trailers can be written
+	w = NewWriter(&bytes.Buffer{}, keepAlive, 0)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(newHeaders("Transfer-Encoding", "chunked", "Trailer", "X-Checksum")))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.Error(t, w.WriteTrailers(newHeaders("X-Checksum", "abc", "X-Other", "1")))
+
+	// Test: Trailer values are validated like header values
+	require.Error(t, w.WriteTrailers(newHeaders("X-Checksum", "\xab\xcd\x00")))
+	require.NoError(t, w.WriteTrailers(newHeaders("X-Checksum", "abcd")))
+}
+
+func TestWriterHeadRequest(t *testing.T) {
+	keepAlive := func() bool { return true }
+
+	// Test: Content-Length is kept and the body discarded
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, keepAlive, 0)
+	w.HeadRequest = true
+	require.NoError(t, w.WriteBody([]byte("hello")))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 5\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Connection: keep-alive\r\n"+
+		"\r\n", buf.String())
+	assert.Equal(t, int64(5), w.BytesWritten())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Chunked body, its framing and trailers are discarded
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	w.HeadRequest = true
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(newHeaders("Transfer-Encoding", "chunked", "Trailer", "X-Checksum")))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(newHeaders("X-Checksum", "abc")))
+	assert.True(t, strings.HasSuffix(buf.String(), "Transfer-Encoding: chunked\r\n"+
+		"Trailer: X-Checksum\r\n"+
+		"Connection: keep-alive\r\n"+
+		"\r\n"))
+	assert.True(t, w.KeepAlive())
+
+	// Test: Buffered body still determines the Content-Length
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	w.HeadRequest = true
+	_, err = w.Write([]byte("hello world"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "Content-Length: 11\r\nConnection: keep-alive\r\n\r\n"))
+
+	// Test: Content-Length set without writing the body
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	w.HeadRequest = true
+	w.Header().Set("Content-Length", "1000")
+	require.NoError(t, w.Flush())
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "Content-Length: 1000\r\n")
+	assert.True(t, w.KeepAlive())
+}
+
+type upperEncoder struct {
+	dst    io.Writer
+	closed bool
+}
+
+func (e *upperEncoder) Write(p []byte) (int, error) {
+	return e.dst.Write(bytes.ToUpper(p))
+}
+
+func (e *upperEncoder) Close() error {
+	e.closed = true
+	_, err := e.dst.Write([]byte("!"))
+	return err
+}
+
+func TestBodyEncoder(t *testing.T) {
+	keepAlive := func() bool { return true }
+	var enc *upperEncoder
+	encode := func(statusCode StatusCode, h *headers.Headers, dst io.Writer) io.WriteCloser {
+		if statusCode != StatusOK {
+			return nil
+		}
+		h.Set("Content-Encoding", "upper")
+		enc = &upperEncoder{dst: dst}
+		return enc
+	}
+
+	// Test: Encoded body is chunked in place of its Content-Length
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf, keepAlive, 0)
+	w.BodyEncoder = encode
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, enc.closed)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Content-Encoding: upper\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"Connection: keep-alive\r\n"+
+		"\r\n"+
+		"5\r\nHELLO\r\n"+
+		"1\r\n!\r\n"+
+		"0\r\n\r\n", buf.String())
+	assert.Equal(t, int64(5), w.BytesWritten())
+	assert.True(t, w.KeepAlive())
+
+	// Test: Content-Length set by the handler still ends the body
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	w.BodyEncoder = encode
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(newHeaders("Content-Length", "5")))
+	require.NoError(t, w.WriteBody([]byte("hel")))
+	require.Error(t, w.WriteBody([]byte("world")))
+	require.NoError(t, w.WriteBody([]byte("lo")))
+	assert.NotContains(t, buf.String(), "Content-Length")
+	assert.True(t, strings.HasSuffix(buf.String(), "3\r\nHEL\r\n2\r\nLO\r\n1\r\n!\r\n0\r\n\r\n"))
+
+	// Test: Trailers follow the encoded body
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	w.BodyEncoder = encode
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	w.Trailer().Set("X-Checksum", "abc")
+	require.NoError(t, w.Finish())
+	res, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO!", string(body))
+	assert.Equal(t, http.Header{"X-Checksum": {"abc"}}, res.Trailer)
+
+	// Test: Encoder declining leaves the response as it is
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	w.BodyEncoder = encode
+	w.WriteHeader(StatusNotFound)
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "Content-Length: 5\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhello"))
+
+	// Test: HTTP/1.0 encoded body is delimited by closing the connection
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf, keepAlive, 0)
+	w.HTTP10 = true
+	w.BodyEncoder = encode
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.NotContains(t, buf.String(), "Transfer-Encoding")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nHELLO!"))
+	assert.False(t, w.KeepAlive())
+}
@@ -0,0 +1,211 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"log"
+	"slices"
+	"strconv"
+)
+
+// writeBufferSize is how much of a body Write holds back to find out whether
+// the whole body fits, in which case it is sent with a Content-Length.
+const writeBufferSize = 4096
+
+// ResponseWriter is the higher-level way of writing a response. The status
+// code and headers are collected first and sent with the first part of the
+// body, which lets the writer choose the framing: bodies that fit in its
+// buffer get a Content-Length, larger or flushed ones are chunked. Setting
+// Content-Length or Transfer-Encoding in Header takes that choice over.
+//
+// Writer implements ResponseWriter alongside its lower-level methods.
+type ResponseWriter interface {
+	Header() *headers.Headers
+	WriteHeader(statusCode StatusCode)
+	Write(p []byte) (int, error)
+	Flush() error
+}
+
+// Header returns the headers to send with the response. DeclareTrailer is
+// the checked way of adding to its Trailer field. Changes made after
+// they have been sent have no effect.
+func (w *Writer) Header() *headers.Headers {
+	if w.header == nil {
+		w.header = headers.NewHeaders()
+	}
+
+	return w.header
+}
+
+// WriteHeader sets the status code to send with the response. Without it,
+// the response is sent as 200 OK. Informational (1xx) codes are ignored, as
+// they cannot be the final status of a response.
+func (w *Writer) WriteHeader(statusCode StatusCode) {
+	if w.Committed() || w.pendingStatus != 0 {
+		log.Printf("error setting status code %v: status code already set\n", int(statusCode))
+		return
+	}
+	if statusCode.Informational() {
+		log.Printf("error setting status code %v: interim responses are not supported\n", int(statusCode))
+		return
+	}
+
+	w.pendingStatus = statusCode
+}
+
+// Write writes p as part of the body, sending the status line and headers
+// first if they have not been sent yet.
+func (w *Writer) Write(p []byte) (int, error) {
+	err := w.checkBodyAllowed(p)
+	if err != nil {
+		return 0, err
+	}
+
+	if !w.Committed() {
+		if !w.hasExplicitFraming() && len(w.buf)+len(p) <= writeBufferSize {
+			w.buf = append(w.buf, p...)
+			return len(p), nil
+		}
+
+		err := w.commit(false)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return w.writeBody(p)
+}
+
+// Flush sends the status line and headers if they have not been sent yet,
+// along with any part of the body Write is holding back. Unless a
+// Content-Length was set, the body of a flushed response is chunked. Output
+// held by a BodyEncoder is sent as well.
+func (w *Writer) Flush() error {
+	if !w.Committed() {
+		err := w.commit(false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.flushEncoder()
+}
+
+// pending reports whether anything was written through ResponseWriter that
+// has not been sent yet.
+func (w *Writer) pending() bool {
+	return !w.Committed() && (w.header != nil || w.pendingStatus != 0 || w.buf != nil)
+}
+
+func (w *Writer) hasExplicitFraming() bool {
+	return w.header != nil && (w.header.Has("content-length") || w.header.Has("transfer-encoding"))
+}
+
+// commit sends the status line and headers collected through WriteHeader and
+// Header, followed by the buffered body. If the headers do not set the
+// framing, the body gets a Content-Length when final, as nothing more will
+// be written, unless trailers were declared. It is chunked otherwise.
+// Responses whose status code does not allow a body get neither, nor a
+// default Content-Type.
+func (w *Writer) commit(final bool) error {
+	statusCode := w.pendingStatus
+	if statusCode == 0 {
+		statusCode = StatusOK
+	}
+
+	h := w.Header().Clone()
+	if !h.Has("content-type") && statusCode.AllowsBody() {
+		h.Set("Content-Type", "text/plain")
+	}
+	if !w.hasExplicitFraming() && statusCode.AllowsBody() {
+		if final && !h.Has("trailer") {
+			h.Set("Content-Length", strconv.Itoa(len(w.buf)))
+		} else {
+			h.Set("Transfer-Encoding", "chunked")
+		}
+	}
+
+	err := w.WriteStatusLine(statusCode)
+	if err != nil {
+		return err
+	}
+	err = w.WriteHeaders(h)
+	if err != nil {
+		return err
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+
+	_, err = w.writeBody(buf)
+
+	return err
+}
+
+// writeBody writes p with the framing the headers were sent with.
+func (w *Writer) writeBody(p []byte) (int, error) {
+	if w.chunked {
+		_, err := w.WriteChunkedBody(p)
+		if err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	err := w.WriteBody(p)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// DeclareTrailer announces in the Trailer header that the named field will be
+// sent as a trailer, which makes the body chunked. It has to be called before
+// the headers are sent and fails for fields that may not be trailers.
+func (w *Writer) DeclareTrailer(headerKey string) error {
+	if w.Committed() {
+		return errors.New("trailers declared after the headers were sent")
+	}
+
+	err := headers.ValidateFieldName(headerKey)
+	if err != nil {
+		return err
+	}
+	if headers.IsForbiddenTrailer(headerKey) {
+		return fmt.Errorf("%v is not allowed as a trailer", headers.CanonicalKey(headerKey))
+	}
+
+	w.Header().Add("Trailer", headers.CanonicalKey(headerKey))
+
+	return nil
+}
+
+// Trailer returns the trailer fields sent once the response is finished.
+// Their values can be set at any point while the body is being written, but
+// only fields declared before the headers were sent are sent.
+func (w *Writer) Trailer() *headers.Headers {
+	if w.trailer == nil {
+		w.trailer = headers.NewHeaders()
+	}
+
+	return w.trailer
+}
+
+// trailerValues returns the fields set through Trailer that were announced.
+func (w *Writer) trailerValues() *headers.Headers {
+	trailers := headers.NewHeaders()
+	for k, v := range w.Trailer().All() {
+		if !slices.Contains(w.announced, headers.CanonicalKey(k)) {
+			log.Printf("error sending trailer %v: not declared\n", headers.CanonicalKey(k))
+			continue
+		}
+		trailers.Add(k, v)
+	}
+
+	return trailers
+}
@@ -0,0 +1,220 @@
+package router
+
+import (
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+const wildcard = "*"
+
+type segmentKind int
+
+const (
+	segmentKindLiteral segmentKind = iota
+	segmentKindParam
+	segmentKindWildcard
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+type route struct {
+	method   string
+	segments []segment
+	handler  server.Handler
+}
+
+// Router dispatches requests to handlers registered by method and path
+// pattern. Patterns are made of "/"-separated segments, each of which is
+// either a literal, a "{name}" parameter matching one non-empty segment, or
+// a trailing "*" matching the rest of the path. Captured values are exposed
+// through Request.PathValue, the wildcard under the name "*".
+type Router struct {
+	routes []route
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("pattern %q does not start with /", pattern)
+	}
+
+	rawSegments := strings.Split(pattern[1:], "/")
+	segments := make([]segment, 0, len(rawSegments))
+	for i, rawSegment := range rawSegments {
+		switch {
+		case rawSegment == wildcard:
+			if i != len(rawSegments)-1 {
+				return nil, fmt.Errorf("pattern %q has a wildcard before its last segment", pattern)
+			}
+			segments = append(segments, segment{kind: segmentKindWildcard, value: wildcard})
+		case strings.HasPrefix(rawSegment, "{") && strings.HasSuffix(rawSegment, "}"):
+			name := rawSegment[1 : len(rawSegment)-1]
+			if name == "" {
+				return nil, fmt.Errorf("pattern %q has an unnamed parameter", pattern)
+			}
+			segments = append(segments, segment{kind: segmentKindParam, value: name})
+		default:
+			segments = append(segments, segment{kind: segmentKindLiteral, value: rawSegment})
+		}
+	}
+
+	return segments, nil
+}
+
+// Handle registers handler for requests with the given method whose path
+// matches pattern. It panics if the pattern is malformed or already
+// registered for method.
+func (rt *Router) Handle(method, pattern string, handler server.Handler) {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, r := range rt.routes {
+		if r.method == method && slices.Equal(r.segments, segments) {
+			panic(fmt.Sprintf("pattern %q is already registered for %v", pattern, method))
+		}
+	}
+
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		segments: segments,
+		handler:  handler,
+	})
+}
+
+// match reports whether path matches r and returns the captured values.
+func (r route) match(pathSegments []string) (map[string]string, bool) {
+	values := map[string]string{}
+	for i, seg := range r.segments {
+		if i >= len(pathSegments) {
+			return nil, false
+		}
+
+		switch seg.kind {
+		case segmentKindWildcard:
+			values[seg.value] = strings.Join(pathSegments[i:], "/")
+			return values, true
+		case segmentKindLiteral:
+			if seg.value != pathSegments[i] {
+				return nil, false
+			}
+		case segmentKindParam:
+			if pathSegments[i] == "" {
+				return nil, false
+			}
+			values[seg.value] = pathSegments[i]
+		}
+	}
+
+	if len(r.segments) != len(pathSegments) {
+		return nil, false
+	}
+
+	return values, true
+}
+
+// moreSpecific reports whether r should win over other when both match the
+// same path: literals beat parameters, which beat wildcards.
+func (r route) moreSpecific(other route) bool {
+	for i := 0; i < len(r.segments) && i < len(other.segments); i++ {
+		if r.segments[i].kind != other.segments[i].kind {
+			return r.segments[i].kind < other.segments[i].kind
+		}
+	}
+
+	return len(r.segments) > len(other.segments)
+}
+
+// pathSegments splits the escaped request path, so an encoded "/" stays
+// inside its segment, and decodes each segment.
+func pathSegments(req *request.Request) []string {
+	segments := strings.Split(strings.TrimPrefix(req.URL.EscapedPath(), "/"), "/")
+	for i, s := range segments {
+		unescaped, err := url.PathUnescape(s)
+		if err == nil {
+			segments[i] = unescaped
+		}
+	}
+
+	return segments
+}
+
+// ServeHTTP dispatches req to the most specific matching route. It answers
+// 404 when no pattern matches the path and 405, with an Allow header, when
+// patterns match but none for the request method. HEAD requests no HEAD
+// route matches are served by the GET route, whose body the response writer
+// discards, so HEAD is allowed wherever GET is. Its method value satisfies
+// server.Handler.
+func (rt *Router) ServeHTTP(w *response.Writer, req *request.Request) {
+	segments := pathSegments(req)
+
+	var best, getRoute *route
+	var bestValues, getValues map[string]string
+	allowed := []string{}
+	for i := range rt.routes {
+		r := &rt.routes[i]
+		values, ok := r.match(segments)
+		if !ok {
+			continue
+		}
+		if r.method != req.RequestLine.Method {
+			if r.method == "GET" && (getRoute == nil || r.moreSpecific(*getRoute)) {
+				getRoute = r
+				getValues = values
+			}
+			if !slices.Contains(allowed, r.method) {
+				allowed = append(allowed, r.method)
+			}
+			continue
+		}
+		if best == nil || r.moreSpecific(*best) {
+			best = r
+			bestValues = values
+		}
+	}
+
+	if best == nil && getRoute != nil && req.RequestLine.Method == "HEAD" {
+		best = getRoute
+		bestValues = getValues
+	}
+	if best == nil && len(allowed) > 0 {
+		if getRoute != nil && !slices.Contains(allowed, "HEAD") {
+			allowed = append(allowed, "HEAD")
+		}
+		slices.Sort(allowed)
+		allow := headers.NewHeaders()
+		allow.Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, response.StatusMethodNotAllowed, allow)
+		return
+	}
+	if best == nil {
+		writeError(w, response.StatusNotFound, nil)
+		return
+	}
+
+	for name, value := range bestValues {
+		req.SetPathValue(name, value)
+	}
+	best.handler(w, req)
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode, h *headers.Headers) {
+	err := w.WriteError(statusCode, h)
+	if err != nil {
+		log.Printf("error writing error response: %v\n", err)
+	}
+}
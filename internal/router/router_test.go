@@ -0,0 +1,148 @@
+package router
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/servertest"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func nameHandler(name string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		body := name
+		for _, param := range []string{"id", "postID", "*"} {
+			if v := req.PathValue(param); v != "" {
+				body += " " + param + "=" + v
+			}
+		}
+
+		w.Write([]byte(body))
+	}
+}
+
+// serve sends a request with method and target through rt and parses what
+// it wrote back
+func serve(t *testing.T, rt *Router, method, target string) (*http.Response, string) {
+	t.Helper()
+
+	return servertest.Serve(t, rt.ServeHTTP, method+" "+target+" HTTP/1.1\r\n"+
+		"Host: localhost:42069\r\n"+
+		"\r\n")
+}
+
+func TestRouter(t *testing.T) {
+	rt := New()
+	rt.Handle("GET", "/", nameHandler("root"))
+	rt.Handle("GET", "/users", nameHandler("users"))
+	rt.Handle("POST", "/users", nameHandler("create-user"))
+	rt.Handle("GET", "/users/me", nameHandler("me"))
+	rt.Handle("GET", "/users/{id}", nameHandler("user"))
+	rt.Handle("DELETE", "/users/{id}", nameHandler("delete-user"))
+	rt.Handle("GET", "/users/{id}/posts/{postID}", nameHandler("post"))
+	rt.Handle("GET", "/static/*", nameHandler("static"))
+
+	// Test: Literal routes
+	res, body := serve(t, rt, "GET", "/")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "root", body)
+	_, body = serve(t, rt, "GET", "/users")
+	assert.Equal(t, "users", body)
+	_, body = serve(t, rt, "POST", "/users")
+	assert.Equal(t, "create-user", body)
+
+	// Test: Path parameters
+	_, body = serve(t, rt, "GET", "/users/42")
+	assert.Equal(t, "user id=42", body)
+	_, body = serve(t, rt, "DELETE", "/users/42")
+	assert.Equal(t, "delete-user id=42", body)
+	_, body = serve(t, rt, "GET", "/users/42/posts/7?draft=true")
+	assert.Equal(t, "post id=42 postID=7", body)
+
+	// Test: Parameters are decoded and an encoded slash stays in its segment
+	_, body = serve(t, rt, "GET", "/users/a%20b")
+	assert.Equal(t, "user id=a b", body)
+	_, body = serve(t, rt, "GET", "/users/a%2Fb")
+	assert.Equal(t, "user id=a/b", body)
+
+	// Test: Literals win over parameters
+	_, body = serve(t, rt, "GET", "/users/me")
+	assert.Equal(t, "me", body)
+
+	// Test: Wildcard suffix
+	_, body = serve(t, rt, "GET", "/static/css/site.css")
+	assert.Equal(t, "static *=css/site.css", body)
+	_, body = serve(t, rt, "GET", "/static/")
+	assert.Equal(t, "static", body)
+
+	// Test: Unknown path
+	res, _ = serve(t, rt, "GET", "/nope")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res, _ = serve(t, rt, "GET", "/users/")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res, _ = serve(t, rt, "GET", "/static")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// Test: Known path with the wrong method
+	res, _ = serve(t, rt, "PUT", "/users/42")
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	assert.Equal(t, "DELETE, GET, HEAD", res.Header.Get("Allow"))
+}
+
+func TestRouterCatchAll(t *testing.T) {
+	rt := New()
+	rt.Handle("GET", "/*", nameHandler("catch-all"))
+	rt.Handle("GET", "/api/{id}", nameHandler("api"))
+
+	// Test: Most specific route wins over the catch-all
+	_, body := serve(t, rt, "GET", "/api/1")
+	assert.Equal(t, "api id=1", body)
+
+	// Test: Everything else falls through to the catch-all
+	_, body = serve(t, rt, "GET", "/")
+	assert.Equal(t, "catch-all", body)
+	_, body = serve(t, rt, "GET", "/api/1/extra")
+	assert.Equal(t, "catch-all *=api/1/extra", body)
+}
+
+func TestRouterInvalidPatterns(t *testing.T) {
+	rt := New()
+	rt.Handle("GET", "/users/{id}", nameHandler("user"))
+
+	assert.Panics(t, func() { rt.Handle("GET", "users", nameHandler("")) })
+	assert.Panics(t, func() { rt.Handle("GET", "/static/*/x", nameHandler("")) })
+	assert.Panics(t, func() { rt.Handle("GET", "/users/{}", nameHandler("")) })
+	assert.Panics(t, func() { rt.Handle("GET", "/users/{id}", nameHandler("")) })
+}
+
+func TestRouterHead(t *testing.T) {
+	rt := New()
+	rt.Handle("GET", "/users/{id}", nameHandler("user"))
+	rt.Handle("GET", "/video", nameHandler("video"))
+	rt.Handle("HEAD", "/video", nameHandler("video-head"))
+	rt.Handle("POST", "/upload", nameHandler("upload"))
+
+	// Test: HEAD falls back to the GET route
+	_, body := serve(t, rt, "HEAD", "/users/42")
+	assert.Equal(t, "user id=42", body)
+
+	// Test: Explicit HEAD route wins
+	_, body = serve(t, rt, "HEAD", "/video")
+	assert.Equal(t, "video-head", body)
+
+	// Test: HEAD without a GET route
+	res, _ := serve(t, rt, "HEAD", "/upload")
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	assert.Equal(t, "POST", res.Header.Get("Allow"))
+
+	// Test: GET-only path allows HEAD
+	res, _ = serve(t, rt, "DELETE", "/users/42")
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	assert.Equal(t, "GET, HEAD", res.Header.Get("Allow"))
+
+	// Test: Explicit HEAD route is listed once
+	res, _ = serve(t, rt, "POST", "/video")
+	assert.Equal(t, "GET, HEAD", res.Header.Get("Allow"))
+}
@@ -0,0 +1,17 @@
+package server
+
+// Middleware wraps a Handler with behavior that runs around it, such as
+// logging, authentication or compression. Middleware can inspect the
+// response.Writer after calling the wrapped handler to observe the status
+// code and number of body bytes it wrote.
+type Middleware func(Handler) Handler
+
+// Chain wraps handler with middlewares. The first middleware is the
+// outermost one: it sees the request first and the finished response last.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *testing.T) {
+	calls := []string{}
+	record := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w *response.Writer, req *request.Request) {
+				calls = append(calls, name+" before")
+				next(w, req)
+				calls = append(calls, name+" after")
+			}
+		}
+	}
+
+	var observedStatus response.StatusCode
+	var observedBytes int64
+	observe := func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			next(w, req)
+			observedStatus = w.StatusCode()
+			observedBytes = w.BytesWritten()
+		}
+	}
+
+	handler := Chain(func(w *response.Writer, req *request.Request) {
+		calls = append(calls, "handler")
+		targetHandler(w, req)
+	}, observe, record("outer"), record("inner"))
+
+	req, err := request.RequestFromReader(strings.NewReader("GET /chained HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"\r\n"))
+	require.NoError(t, err)
+	buf := &bytes.Buffer{}
+	handler(&response.Writer{Res: buf}, req)
+
+	// Test: Middlewares run outermost first
+	assert.Equal(t, []string{
+		"outer before",
+		"inner before",
+		"handler",
+		"inner after",
+		"outer after",
+	}, calls)
+
+	// Test: Middleware observes what the handler wrote
+	assert.Equal(t, response.StatusOK, observedStatus)
+	assert.Equal(t, int64(len("/chained")), observedBytes)
+
+	// Test: No middlewares
+	calls = nil
+	Chain(func(w *response.Writer, req *request.Request) {
+		calls = append(calls, "handler")
+	})(&response.Writer{Res: buf}, req)
+	assert.Equal(t, []string{"handler"}, calls)
+}
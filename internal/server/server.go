@@ -1,22 +1,45 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"httpfromtcp/internal/headers"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
 	"io"
 	"log"
 	"net"
+	"os"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
+const defaultIdleTimeout = 60 * time.Second
+
+// maxDrainBytes caps how much of an unread request body is discarded to keep
+// the connection alive before giving up and closing it instead.
+const maxDrainBytes = 256 << 10
+
+const shutdownPollInterval = 10 * time.Millisecond
+
 type HandlerError struct {
 	StatusCode    response.StatusCode
 	StatusMessage string
 }
 
+func newHandlerError(statusCode response.StatusCode) *HandlerError {
+	return &HandlerError{
+		StatusCode:    statusCode,
+		StatusMessage: response.StatusText(statusCode),
+	}
+}
+
+// write answers with the plain-text error page for the status code and asks
+// the client to close the connection.
 func (hErr *HandlerError) write(w io.Writer) error {
-	_, err := w.Write([]byte(fmt.Sprintf("%v %v", hErr.StatusCode, hErr.StatusMessage)))
+	err := response.NewWriter(w, nil, 0).WriteError(hErr.StatusCode, nil)
 	if err != nil {
 		log.Printf("error writing handler error: %v\n", err)
 		return err
@@ -27,42 +50,356 @@ func (hErr *HandlerError) write(w io.Writer) error {
 
 type Handler func(w *response.Writer, req *request.Request)
 
+type connStatus int
+
+const (
+	connStatusActive connStatus = iota
+	connStatusIdle
+)
+
 type Server struct {
-	Port      int
-	listener  net.Listener
-	connState atomic.Bool
-	handler   Handler
+	Port    int
+	Handler Handler
+
+	// ReadHeaderTimeout is how long a client has to send the request line
+	// and headers. If zero, ReadTimeout is used.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout is how long a client has to send the whole request,
+	// including the body, measured from its first byte.
+	ReadTimeout time.Duration
+	// WriteTimeout is how long the handler has to write the response,
+	// measured from the end of the request headers.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a keep-alive connection waits for the next
+	// request. If zero, ReadTimeout is used, and if that is zero too, a
+	// default of 60 seconds.
+	IdleTimeout time.Duration
+
+	// MaxRequestLineBytes, MaxHeaderBytes and MaxHeaderCount bound the
+	// request line and header section; requests over them are answered with
+	// 414 and 431. Zero means the request.DefaultLimits value.
+	MaxRequestLineBytes int
+	MaxHeaderBytes      int
+	MaxHeaderCount      int
+	// MaxBodyBytes bounds request bodies; larger ones are answered with 413.
+	// Zero means no limit.
+	MaxBodyBytes int64
+	// DecompressRequests has request bodies sent with a gzip or deflate
+	// Content-Encoding decoded before handlers read them. The decoded size
+	// counts against MaxBodyBytes, or request.DefaultMaxDecodedBodyBytes if
+	// that is zero. Other codings are answered with 415.
+	DecompressRequests bool
+
+	listener   net.Listener
+	connState  atomic.Bool
+	inShutdown atomic.Bool
+	mu         sync.Mutex
+	conns      map[net.Conn]connStatus
 }
 
+// Close immediately stops the server, closing the listener and every open
+// connection, including ones with requests in flight.
 func (s *Server) Close() error {
-	s.connState.CompareAndSwap(true, false)
-	return s.listener.Close()
+	s.connState.Store(false)
+	s.inShutdown.Store(true)
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+
+	return err
+}
+
+// Shutdown stops accepting connections, closes idle keep-alive connections
+// and waits for active requests to finish. If ctx expires first, remaining
+// connections are closed and the context's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.connState.Store(false)
+	s.inShutdown.Store(true)
+	err := s.listener.Close()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdleConns closes connections waiting for their next request and
+// reports whether no connections are left.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, status := range s.conns {
+		if status == connStatusIdle {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+
+	return len(s.conns) == 0
+}
+
+func (s *Server) shuttingDown() bool {
+	return s.inShutdown.Load()
+}
+
+// trackConn records the status of conn and reports whether it may keep
+// serving requests, which it may not once the server is shutting down.
+func (s *Server) trackConn(conn net.Conn, status connStatus) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.shuttingDown() {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]connStatus)
+	}
+	s.conns[conn] = status
+
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, conn)
+}
+
+func (s *Server) numConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+func (s *Server) readHeaderTimeout() time.Duration {
+	if s.ReadHeaderTimeout > 0 {
+		return s.ReadHeaderTimeout
+	}
+
+	return s.ReadTimeout
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout > 0 {
+		return s.IdleTimeout
+	}
+	if s.ReadTimeout > 0 {
+		return s.ReadTimeout
+	}
+
+	return defaultIdleTimeout
+}
+
+func deadline(start time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+
+	return start.Add(timeout)
+}
+
+func isTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+func (s *Server) limits() request.Limits {
+	limits := request.DefaultLimits
+	if s.MaxRequestLineBytes > 0 {
+		limits.MaxRequestLineBytes = s.MaxRequestLineBytes
+	}
+	if s.MaxHeaderBytes > 0 {
+		limits.MaxHeaderBytes = s.MaxHeaderBytes
+	}
+	if s.MaxHeaderCount > 0 {
+		limits.MaxHeaderCount = s.MaxHeaderCount
+	}
+	limits.MaxBodyBytes = s.MaxBodyBytes
+
+	return limits
+}
+
+// errorStatus returns the status code to answer a request that failed with
+// err, and reports whether the client is to blame for the failure.
+func errorStatus(err error) (response.StatusCode, bool) {
+	switch {
+	case isTimeout(err):
+		return response.StatusRequestTimeout, true
+	case errors.Is(err, request.ErrRequestLineTooLong):
+		return response.StatusURITooLong, true
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		return response.StatusRequestHeaderFieldsTooLarge, true
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.StatusContentTooLarge, true
+	case errors.Is(err, request.ErrUnsupportedVersion):
+		return response.StatusHTTPVersionNotSupported, true
+	case errors.Is(err, request.ErrUnsupportedContentEncoding):
+		return response.StatusUnsupportedMediaType, true
+	case errors.Is(err, request.ErrMalformedRequestLine),
+		errors.Is(err, request.ErrInvalidRequestTarget),
+		errors.Is(err, request.ErrInvalidHost),
+		errors.Is(err, request.ErrIncompleteRequest),
+		errors.Is(err, request.ErrInvalidContentLength),
+		errors.Is(err, request.ErrInvalidTransferEncoding),
+		errors.Is(err, request.ErrAmbiguousFraming),
+		errors.Is(err, request.ErrLengthMismatch),
+		errors.Is(err, request.ErrMalformedChunk),
+		errors.Is(err, request.ErrInvalidContentEncoding),
+		errors.Is(err, headers.ErrMalformedFieldLine),
+		errors.Is(err, headers.ErrInvalidFieldName),
+		errors.Is(err, headers.ErrInvalidFieldValue):
+		return response.StatusBadRequest, true
+	default:
+		return response.StatusInternalServerError, false
+	}
 }
 
+func (s *Server) writeError(conn net.Conn, statusCode response.StatusCode) {
+	conn.SetWriteDeadline(deadline(time.Now(), s.WriteTimeout))
+	newHandlerError(statusCode).write(conn)
+}
+
+// handle serves requests from conn one at a time until the connection can no
+// longer be kept alive. Pipelined requests wait in the parser's buffer, so
+// their responses are always written in request order.
 func (s *Server) handle(conn net.Conn) {
 	defer conn.Close()
+	defer s.untrackConn(conn)
 
-	req, err := request.RequestFromReader(conn)
-	if err != nil {
-		log.Printf("error getting request from connection: %v\n", err)
-		hErr := &HandlerError{
-			StatusCode:    response.StatusInternalServerError,
-			StatusMessage: err.Error(),
+	parser := request.NewParser(conn)
+	parser.Limits = s.limits()
+	parser.DecodeContent = s.DecompressRequests
+	for firstRequest := true; ; firstRequest = false {
+		// the first request has to arrive within the read-header timeout of
+		// the connection being accepted, later ones within the idle timeout
+		if firstRequest {
+			conn.SetReadDeadline(deadline(time.Now(), s.readHeaderTimeout()))
+		} else {
+			conn.SetReadDeadline(time.Now().Add(s.idleTimeout()))
+		}
+
+		if !s.trackConn(conn, connStatusIdle) {
+			return
+		}
+
+		err := parser.WaitForRequest()
+		if err != nil {
+			return
+		}
+
+		if !s.trackConn(conn, connStatusActive) {
+			return
+		}
+
+		start := time.Now()
+		if !firstRequest {
+			conn.SetReadDeadline(deadline(start, s.readHeaderTimeout()))
+		}
+
+		req, err := parser.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Printf("error getting request from connection: %v\n", err)
+			statusCode, _ := errorStatus(err)
+			s.writeError(conn, statusCode)
+			return
+		}
+
+		conn.SetReadDeadline(deadline(start, s.ReadTimeout))
+		conn.SetWriteDeadline(deadline(time.Now(), s.WriteTimeout))
+
+		body := &handlerBody{ReadCloser: req.Body}
+		req.Body = body
+		canKeepAlive := func() bool {
+			return req.KeepAlive() && !s.shuttingDown() && canDrain(parser, body)
+		}
+		resWriter := response.NewWriter(conn, canKeepAlive, s.idleTimeout())
+		resWriter.HTTP10 = req.RequestLine.HttpVersion == "1.0"
+		resWriter.HeadRequest = req.RequestLine.Method == "HEAD"
+		s.Handler(resWriter, req)
+
+		if statusCode, ok := errorStatus(body.err); ok && !resWriter.Committed() {
+			s.writeError(conn, statusCode)
+			return
+		}
+
+		err = resWriter.Finish()
+		if err != nil {
+			log.Printf("error finishing response: %v\n", err)
+			return
+		}
+
+		if !resWriter.KeepAlive() {
+			return
+		}
+
+		err = drainBody(parser, body)
+		if err != nil {
+			log.Printf("error draining request body: %v\n", err)
+			return
 		}
-		hErr.write(conn)
-		return
 	}
+}
 
-	resWriter := response.Writer{
-		Res: conn,
+// handlerBody keeps the first error the handler got reading the request
+// body, so the request can be answered for it if the handler did not.
+type handlerBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (hb *handlerBody) Read(p []byte) (int, error) {
+	n, err := hb.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && hb.err == nil {
+		hb.err = err
 	}
-	s.handler(&resWriter, req)
+
+	return n, err
+}
+
+// canDrain reports whether what the handler left unread of the request body
+// is known to be small enough to be discarded to keep the connection alive.
+// It is decided before the response headers are sent, so they can announce
+// whether the connection will be reused.
+func canDrain(parser *request.Parser, body *handlerBody) bool {
+	unread := parser.UnreadBody()
+	return body.err == nil && unread >= 0 && unread <= maxDrainBytes
+}
+
+// drainBody discards what the handler left unread of the request body so
+// the next request can be read. It goes through the parser, as the handler
+// may have closed the body.
+func drainBody(parser *request.Parser, body *handlerBody) error {
+	defer body.Close()
+
+	return parser.DrainBody(maxDrainBytes)
 }
 
 func (s *Server) listen() {
 	for {
 		conn, err := s.listener.Accept()
 		if !s.connState.Load() {
+			if conn != nil {
+				conn.Close()
+			}
 			return
 		}
 		if err != nil {
@@ -73,21 +410,33 @@ func (s *Server) listen() {
 	}
 }
 
-func Serve(port int, handler Handler) (*Server, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%v", port))
+// Start announces the server on Port and serves connections in the
+// background. Timeouts have to be set before Start is called.
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%v", s.Port))
 	if err != nil {
 		log.Printf("error announcing local network address: %v\n", err)
-		return nil, err
+		return err
 	}
 
-	s := &Server{
-		Port:     port,
-		listener: listener,
-		handler:  handler,
-	}
+	s.listener = listener
 	s.connState.Store(true)
 
 	go s.listen()
 
+	return nil
+}
+
+func Serve(port int, handler Handler) (*Server, error) {
+	s := &Server{
+		Port:    port,
+		Handler: handler,
+	}
+
+	err := s.Start()
+	if err != nil {
+		return nil, err
+	}
+
 	return s, nil
 }
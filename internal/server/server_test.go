@@ -0,0 +1,719 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveConn hands one end of an in-memory connection to s and returns the
+// other end for the test to act as the client
+func serveConn(s *Server) net.Conn {
+	client, conn := net.Pipe()
+	go s.handle(conn)
+	return client
+}
+
+// writeSlowly writes data one byte at a time, pausing between each byte
+// like a slowloris client would
+func writeSlowly(conn net.Conn, data string, delay time.Duration) {
+	for i := range len(data) {
+		_, err := conn.Write([]byte{data[i]})
+		if err != nil {
+			return
+		}
+		time.Sleep(delay)
+	}
+}
+
+func targetHandler(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.RequestTarget)
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func readBody(t *testing.T, res *http.Response) string {
+	t.Helper()
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return string(body)
+}
+
+func TestKeepAlive(t *testing.T) {
+	s := &Server{Handler: targetHandler}
+	client := serveConn(s)
+	defer client.Close()
+	br := bufio.NewReader(client)
+
+	// Test: Connection stays open between requests
+	for _, target := range []string{"/first", "/second"} {
+		go client.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		res, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "keep-alive", res.Header.Get("Connection"))
+		assert.Equal(t, target, readBody(t, res))
+	}
+
+	// Test: Connection: close ends the connection after the response
+	go client.Write([]byte("GET /last HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.True(t, res.Close)
+	assert.Equal(t, "/last", readBody(t, res))
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestKeepAliveClosedBody(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		defer req.Body.Close()
+		targetHandler(w, req)
+	}
+	s := &Server{Handler: handler}
+	client := serveConn(s)
+	defer client.Close()
+	br := bufio.NewReader(client)
+
+	// Test: Closing the body without reading it keeps the connection usable
+	for _, raw := range []string{
+		"GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		"POST /second HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello",
+		"GET /third HTTP/1.1\r\nHost: localhost\r\n\r\n",
+	} {
+		go client.Write([]byte(raw))
+		res, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		assert.False(t, res.Close)
+		target := strings.Fields(raw)[1]
+		assert.Equal(t, target, readBody(t, res))
+	}
+
+	// Test: Unread chunked body of unknown size closes the connection
+	go client.Write([]byte("POST /fourth HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\nhello\r\n0\r\n\r\n"))
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.True(t, res.Close)
+	assert.Equal(t, "/fourth", readBody(t, res))
+}
+
+func TestUndrainedBody(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		w.Write([]byte("accepted"))
+	}
+	s := &Server{Handler: handler}
+
+	// Test: Response to a body too large to drain announces the close
+	client := serveConn(s)
+	defer client.Close()
+	body := strings.Repeat("a", 300<<10)
+	go client.Write([]byte(fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: %v\r\n\r\n%v",
+		len(body), body)))
+	br := bufio.NewReader(client)
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.True(t, res.Close)
+	assert.Equal(t, "accepted", readBody(t, res))
+
+	// Test: Buffered response is sent before the unread body arrives
+	client = serveConn(s)
+	defer client.Close()
+	go client.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nabc"))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	br = bufio.NewReader(client)
+	res, err = http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.False(t, res.Close)
+	assert.Equal(t, "accepted", readBody(t, res))
+
+	// Test: Rest of the body is drained and the connection reused
+	go client.Write([]byte("defghij" + "GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	res, err = http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "accepted", readBody(t, res))
+}
+
+func TestPipelinedResponsesInOrder(t *testing.T) {
+	s := &Server{Handler: targetHandler}
+	client := serveConn(s)
+	defer client.Close()
+	br := bufio.NewReader(client)
+
+	go client.Write([]byte("GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+		"POST /second HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc" +
+		"GET /third HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+
+	for _, target := range []string{"/first", "/second", "/third"} {
+		res, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		assert.Equal(t, target, readBody(t, res))
+	}
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	s := &Server{
+		Handler:           targetHandler,
+		ReadHeaderTimeout: 100 * time.Millisecond,
+	}
+	client := serveConn(s)
+	defer client.Close()
+
+	// Test: Slowloris client gets a 408 and is disconnected
+	go writeSlowly(client, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", 20*time.Millisecond)
+	br := bufio.NewReader(client)
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestTimeout, res.StatusCode)
+	assert.True(t, res.Close)
+	readBody(t, res)
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	s := &Server{
+		Handler:     targetHandler,
+		IdleTimeout: 50 * time.Millisecond,
+	}
+	client := serveConn(s)
+	defer client.Close()
+	br := bufio.NewReader(client)
+
+	go client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/", readBody(t, res))
+
+	// Test: Idle keep-alive connection is closed without a response
+	start := time.Now()
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestReadTimeout(t *testing.T) {
+	bodyErr := make(chan error, 1)
+	s := &Server{
+		Handler: func(w *response.Writer, req *request.Request) {
+			_, err := req.ReadBody()
+			bodyErr <- err
+		},
+		ReadTimeout: 100 * time.Millisecond,
+	}
+	client := serveConn(s)
+	defer client.Close()
+
+	// Test: Body that stops arriving fails the handler's read
+	go client.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nabc"))
+	select {
+	case err := <-bodyErr:
+		require.Error(t, err)
+		assert.True(t, isTimeout(err))
+	case <-time.After(time.Second):
+		t.Fatal("handler did not time out reading the body")
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	writeErr := make(chan error, 1)
+	s := &Server{
+		Handler: func(w *response.Writer, req *request.Request) {
+			writeErr <- w.WriteStatusLine(response.StatusOK)
+		},
+		WriteTimeout: 50 * time.Millisecond,
+	}
+	client := serveConn(s)
+	defer client.Close()
+
+	// Test: Client that never reads the response fails the handler's write
+	go client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	select {
+	case err := <-writeErr:
+		require.Error(t, err)
+		assert.True(t, isTimeout(err))
+	case <-time.After(time.Second):
+		t.Fatal("handler did not time out writing the response")
+	}
+}
+
+// startServer serves handler on a random local port
+func startServer(t *testing.T, handler Handler) (*Server, string) {
+	t.Helper()
+
+	s := &Server{Handler: handler}
+	require.NoError(t, s.Start())
+
+	return s, s.listener.Addr().String()
+}
+
+func TestShutdownWaitsForActiveRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+		targetHandler(w, req)
+	})
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- s.Shutdown(context.Background())
+	}()
+
+	// Test: Shutdown blocks while the handler is running
+	select {
+	case <-shutdownErr:
+		t.Fatal("shutdown returned before the active request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Test: New connections are refused
+	_, err = net.Dial("tcp", addr)
+	require.Error(t, err)
+
+	// Test: In-flight response is completed and the connection closed
+	close(release)
+	br := bufio.NewReader(client)
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.True(t, res.Close)
+	assert.Equal(t, "/slow", readBody(t, res))
+
+	require.NoError(t, <-shutdownErr)
+	assert.Equal(t, 0, s.numConns())
+}
+
+func TestShutdownClosesIdleConns(t *testing.T) {
+	s, addr := startServer(t, targetHandler)
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	br := bufio.NewReader(client)
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	readBody(t, res)
+
+	// Test: Idle keep-alive connection does not hold up shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	assert.Equal(t, 0, s.numConns())
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestShutdownClosesSilentConns(t *testing.T) {
+	s, addr := startServer(t, targetHandler)
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	require.Eventually(t, func() bool { return s.numConns() == 1 }, time.Second, time.Millisecond)
+
+	// Test: Connection that has not sent a request does not hold up shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	assert.Equal(t, 0, s.numConns())
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = client.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+// acceptListener hands out the connections sent on conns
+type acceptListener struct {
+	conns chan net.Conn
+}
+
+func (l *acceptListener) Accept() (net.Conn, error) { return <-l.conns, nil }
+func (l *acceptListener) Close() error              { return nil }
+func (l *acceptListener) Addr() net.Addr            { return &net.TCPAddr{} }
+
+func TestAcceptAfterShutdown(t *testing.T) {
+	listener := &acceptListener{conns: make(chan net.Conn)}
+	s := &Server{Handler: targetHandler, listener: listener}
+	s.connState.Store(true)
+	done := make(chan struct{})
+	go func() {
+		s.listen()
+		close(done)
+	}()
+
+	// Test: Connection accepted after shutdown started is closed
+	client, conn := net.Pipe()
+	defer client.Close()
+	s.connState.Store(false)
+	listener.conns <- conn
+	<-done
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := client.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, s.numConns())
+}
+
+func TestShutdownContextExpired(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	s, addr := startServer(t, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	})
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	// Test: Remaining connections are force-closed when the context expires
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = s.Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	_, err = bufio.NewReader(client).ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestRequestLimits(t *testing.T) {
+	echoHandler := func(w *response.Writer, req *request.Request) {
+		body, err := req.ReadBody()
+		if err != nil {
+			return
+		}
+		w.WriteBody(body)
+	}
+	s := &Server{
+		Handler:             echoHandler,
+		MaxRequestLineBytes: 32,
+		MaxHeaderBytes:      64,
+		MaxBodyBytes:        10,
+	}
+
+	tests := []struct {
+		name       string
+		request    string
+		statusCode int
+	}{
+		{
+			name:       "Request line too long",
+			request:    "GET /" + strings.Repeat("a", 64) + " HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			statusCode: http.StatusRequestURITooLong,
+		},
+		{
+			name:       "Header section too large",
+			request:    "GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: " + strings.Repeat("a", 64) + "\r\n\r\n",
+			statusCode: http.StatusRequestHeaderFieldsTooLarge,
+		},
+		{
+			name:       "Declared body too large",
+			request:    "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\nhello world",
+			statusCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "Chunked body too large",
+			request:    "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nhello \r\n5\r\nworld\r\n0\r\n\r\n",
+			statusCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		// Test: Oversized requests get their status code and are disconnected
+		client := serveConn(s)
+		go client.Write([]byte(tt.request))
+		br := bufio.NewReader(client)
+		res, err := http.ReadResponse(br, nil)
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.statusCode, res.StatusCode, tt.name)
+		assert.True(t, res.Close, tt.name)
+		readBody(t, res)
+		_, err = br.ReadByte()
+		assert.Equal(t, io.EOF, err, tt.name)
+		client.Close()
+	}
+}
+
+func TestParseErrorResponses(t *testing.T) {
+	s := &Server{Handler: targetHandler}
+
+	tests := []struct {
+		name       string
+		request    string
+		statusCode int
+	}{
+		{
+			name:       "Malformed request line",
+			request:    "get / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Fragment in request target",
+			request:    "GET /page#top HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Unsupported version",
+			request:    "GET / HTTP/2.0\r\nHost: localhost\r\n\r\n",
+			statusCode: http.StatusHTTPVersionNotSupported,
+		},
+		{
+			name:       "Malformed header",
+			request:    "GET / HTTP/1.1\r\nHost localhost\r\n\r\n",
+			statusCode: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid content length",
+			request:    "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: ten\r\n\r\n",
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		// Test: Parse errors are answered with a well-formed response
+		client := serveConn(s)
+		go client.Write([]byte(tt.request))
+		br := bufio.NewReader(client)
+		res, err := http.ReadResponse(br, nil)
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.statusCode, res.StatusCode, tt.name)
+		assert.True(t, res.Close, tt.name)
+		assert.Equal(t, fmt.Sprintf("%v %v\n", tt.statusCode, http.StatusText(tt.statusCode)), readBody(t, res), tt.name)
+		client.Close()
+	}
+}
+
+func TestHTTP10(t *testing.T) {
+	chunkedHandler := func(w *response.Writer, req *request.Request) {
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+	}
+	s := &Server{Handler: chunkedHandler}
+	client := serveConn(s)
+	defer client.Close()
+
+	// Test: HTTP/1.0 clients get an unchunked body ended by closing the connection
+	go client.Write([]byte("GET / HTTP/1.0\r\n\r\n"))
+	br := bufio.NewReader(client)
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Empty(t, res.TransferEncoding)
+	assert.True(t, res.Close)
+	assert.Equal(t, "hello world", readBody(t, res))
+}
+
+func TestSmuggledRequestNotServed(t *testing.T) {
+	served := make(chan string, 2)
+	handler := func(w *response.Writer, req *request.Request) {
+		served <- req.RequestLine.RequestTarget
+		targetHandler(w, req)
+	}
+	s := &Server{Handler: handler}
+	client := serveConn(s)
+	defer client.Close()
+
+	// Test: CL.TE request is answered with 400 and the connection closed
+	go client.Write([]byte("POST / HTTP/1.1\r\n" +
+		"Host: localhost\r\n" +
+		"Content-Length: 6\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"0\r\n\r\n" +
+		"GET /admin HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	br := bufio.NewReader(client)
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.True(t, res.Close)
+	readBody(t, res)
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+	assert.Empty(t, served)
+}
+
+func TestBufferedResponse(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		w.WriteHeader(response.StatusAccepted)
+		w.Write([]byte("queued"))
+	}
+	s := &Server{Handler: handler}
+	client := serveConn(s)
+	defer client.Close()
+
+	// Test: Body left buffered by the handler is sent with a Content-Length
+	go client.Write([]byte("POST /jobs HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	res, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+	assert.Equal(t, int64(6), res.ContentLength)
+	assert.Equal(t, "queued", readBody(t, res))
+}
+
+func TestEmptyResponse(t *testing.T) {
+	s := &Server{Handler: func(w *response.Writer, req *request.Request) {}}
+	client := serveConn(s)
+	defer client.Close()
+	br := bufio.NewReader(client)
+
+	// Test: Handler that writes nothing gets an empty 200 OK
+	for range 2 {
+		go client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		res, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "", readBody(t, res))
+		assert.False(t, res.Close)
+	}
+}
+
+func TestHeadRequest(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/stream" {
+			w.Write([]byte(strings.Repeat("a", 10000)))
+			return
+		}
+		targetHandler(w, req)
+	}
+	s := &Server{Handler: handler}
+	client := serveConn(s)
+	defer client.Close()
+	br := bufio.NewReader(client)
+	head := &http.Request{Method: "HEAD"}
+
+	// Test: Length-known response keeps its Content-Length without a body
+	go client.Write([]byte("HEAD /page HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	res, err := http.ReadResponse(br, head)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "5", res.Header.Get("Content-Length"))
+	assert.False(t, res.Close)
+
+	// Test: Chunked response is sent without a body
+	go client.Write([]byte("HEAD /stream HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	res, err = http.ReadResponse(br, head)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.False(t, res.Close)
+
+	// Test: Next response follows directly, so no body bytes were sent
+	go client.Write([]byte("GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	res, err = http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "/next", readBody(t, res))
+}
+
+func TestDecompressRequests(t *testing.T) {
+	echoHandler := func(w *response.Writer, req *request.Request) {
+		body, err := req.ReadBody()
+		if err != nil {
+			return
+		}
+		w.Write(body)
+	}
+	s := &Server{
+		Handler:            echoHandler,
+		MaxBodyBytes:       1024,
+		DecompressRequests: true,
+	}
+	gzipped := func(data string) string {
+		buf := &bytes.Buffer{}
+		zw := gzip.NewWriter(buf)
+		zw.Write([]byte(data))
+		zw.Close()
+		return buf.String()
+	}
+	post := func(contentEncoding, body string) string {
+		return fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: localhost\r\n"+
+			"Content-Encoding: %v\r\nContent-Length: %v\r\n\r\n%v", contentEncoding, len(body), body)
+	}
+
+	// Test: gzip body reaches the handler decoded
+	client := serveConn(s)
+	br := bufio.NewReader(client)
+	go client.Write([]byte(post("gzip", gzipped("hello world"))))
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "hello world", readBody(t, res))
+	assert.False(t, res.Close)
+	client.Close()
+
+	tests := []struct {
+		name       string
+		request    string
+		statusCode int
+	}{
+		{
+			name:       "Unknown coding",
+			request:    post("br", "hello world"),
+			statusCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:       "Decoded body too large",
+			request:    post("gzip", gzipped(strings.Repeat("a", 64<<10))),
+			statusCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "Corrupt gzip data",
+			request:    post("gzip", "hello world"),
+			statusCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		// Test: Bodies that cannot be decoded get their status code
+		client := serveConn(s)
+		go client.Write([]byte(tt.request))
+		res, err := http.ReadResponse(bufio.NewReader(client), nil)
+		require.NoError(t, err, tt.name)
+		assert.Equal(t, tt.statusCode, res.StatusCode, tt.name)
+		assert.True(t, res.Close, tt.name)
+		client.Close()
+	}
+
+	// Test: Decoded size is bounded with default limits
+	s = &Server{Handler: echoHandler, DecompressRequests: true}
+	client = serveConn(s)
+	go client.Write([]byte(post("gzip", gzipped(strings.Repeat("a", request.DefaultMaxDecodedBodyBytes+1)))))
+	res, err = http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+	client.Close()
+
+	// Test: Bodies are left encoded unless enabled
+	s = &Server{Handler: echoHandler}
+	client = serveConn(s)
+	defer client.Close()
+	go client.Write([]byte(post("br", "hello world")))
+	res, err = http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", readBody(t, res))
+}
@@ -0,0 +1,37 @@
+package servertest
+
+import (
+	"bufio"
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Serve runs rawRequest through handler, finishes the response like the
+// server would and parses what was written back.
+func Serve(t *testing.T, handler server.Handler, rawRequest string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := request.RequestFromReader(strings.NewReader(rawRequest))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	w := &response.Writer{Res: buf}
+	handler(w, req)
+	require.NoError(t, w.Finish())
+
+	res, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return res, string(body)
+}
@@ -0,0 +1,98 @@
+package vhost
+
+import (
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"log"
+	"net"
+	"strings"
+)
+
+// Mux dispatches requests to handlers by the host name in their Host header.
+// Host names are registered either exactly, as "example.com", or as a
+// wildcard matching every subdomain, as "*.example.com". Exact names win
+// over wildcards and longer wildcards over shorter ones. Requests for any
+// other host, or without a Host header, go to Default, or are answered with
+// 421 Misdirected Request if it is nil.
+type Mux struct {
+	Default server.Handler
+
+	hosts     map[string]server.Handler
+	wildcards map[string]server.Handler
+}
+
+func New() *Mux {
+	return &Mux{
+		hosts:     map[string]server.Handler{},
+		wildcards: map[string]server.Handler{},
+	}
+}
+
+// hostName returns the lower-cased host name of a Host header value, without
+// its port or a trailing dot.
+func hostName(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.TrimSuffix(host, ".")
+
+	return strings.ToLower(strings.Trim(host, "[]"))
+}
+
+// Handle registers handler for host. It panics if host is empty or already
+// registered.
+func (m *Mux) Handle(host string, handler server.Handler) {
+	hosts := m.hosts
+	if suffix, ok := strings.CutPrefix(host, "*."); ok {
+		hosts = m.wildcards
+		host = suffix
+	}
+
+	name := hostName(host)
+	if name == "" || strings.Contains(name, "*") {
+		panic(fmt.Sprintf("invalid host %q", host))
+	}
+	if _, ok := hosts[name]; ok {
+		panic(fmt.Sprintf("host %q is already registered", host))
+	}
+
+	hosts[name] = handler
+}
+
+// handler returns the handler registered for name, falling back to the
+// wildcards covering it from the most to the least specific.
+func (m *Mux) handler(name string) server.Handler {
+	if handler, ok := m.hosts[name]; ok {
+		return handler
+	}
+
+	for suffix := name; ; {
+		_, parent, ok := strings.Cut(suffix, ".")
+		if !ok {
+			break
+		}
+		if handler, ok := m.wildcards[parent]; ok {
+			return handler
+		}
+		suffix = parent
+	}
+
+	return m.Default
+}
+
+// ServeHTTP dispatches req to the handler registered for its host. Its
+// method value satisfies server.Handler.
+func (m *Mux) ServeHTTP(w *response.Writer, req *request.Request) {
+	handler := m.handler(hostName(req.Headers.Get("host")))
+	if handler == nil {
+		err := w.WriteError(response.StatusMisdirectedRequest, nil)
+		if err != nil {
+			log.Printf("error writing error response: %v\n", err)
+		}
+		return
+	}
+
+	handler(w, req)
+}
@@ -0,0 +1,75 @@
+package vhost
+
+import (
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/servertest"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func nameHandler(name string) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, req *request.Request) {
+		w.Write([]byte(name))
+	}
+}
+
+// serve runs a request for host through m and parses what it wrote back
+func serve(t *testing.T, m *Mux, host string) (*http.Response, string) {
+	t.Helper()
+
+	return servertest.Serve(t, m.ServeHTTP, "GET / HTTP/1.1\r\n"+
+		"Host: "+host+"\r\n"+
+		"\r\n")
+}
+
+func TestMux(t *testing.T) {
+	m := New()
+	m.Handle("example.com", nameHandler("example"))
+	m.Handle("*.example.com", nameHandler("example-wildcard"))
+	m.Handle("*.api.example.com", nameHandler("api-wildcard"))
+	m.Handle("static.example.com", nameHandler("static"))
+
+	// Test: Exact host, with or without a port
+	_, body := serve(t, m, "example.com")
+	assert.Equal(t, "example", body)
+	_, body = serve(t, m, "EXAMPLE.com:42069")
+	assert.Equal(t, "example", body)
+	_, body = serve(t, m, "example.com.")
+	assert.Equal(t, "example", body)
+
+	// Test: Exact host wins over a wildcard
+	_, body = serve(t, m, "static.example.com")
+	assert.Equal(t, "static", body)
+
+	// Test: Wildcard subdomains, most specific first
+	_, body = serve(t, m, "blog.example.com")
+	assert.Equal(t, "example-wildcard", body)
+	_, body = serve(t, m, "a.b.example.com")
+	assert.Equal(t, "example-wildcard", body)
+	_, body = serve(t, m, "v1.api.example.com")
+	assert.Equal(t, "api-wildcard", body)
+
+	// Test: Unknown host without a default
+	res, _ := serve(t, m, "example.org")
+	assert.Equal(t, http.StatusMisdirectedRequest, res.StatusCode)
+
+	// Test: Unknown host with a default
+	m.Default = nameHandler("default")
+	_, body = serve(t, m, "example.org")
+	assert.Equal(t, "default", body)
+	_, body = serve(t, m, "127.0.0.1")
+	assert.Equal(t, "default", body)
+}
+
+func TestMuxInvalidHosts(t *testing.T) {
+	m := New()
+	m.Handle("example.com", nameHandler(""))
+
+	assert.Panics(t, func() { m.Handle("", nameHandler("")) })
+	assert.Panics(t, func() { m.Handle("*.", nameHandler("")) })
+	assert.Panics(t, func() { m.Handle("a.*.example.com", nameHandler("")) })
+	assert.Panics(t, func() { m.Handle("Example.com", nameHandler("")) })
+}